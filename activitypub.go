@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	activityPub     = flag.Bool("activitypub", false, "publish page changes to ActivityPub followers (needs -base-url)")
+	apKeyFile       = flag.String("activitypub-key", "activitypub.pem", "`file` holding the wiki actor's private key")
+	apFollowersFile = flag.String("activitypub-followers", "followers.json", "`file` the wiki actor's followers are kept in")
+)
+
+const (
+	apContentType = "application/activity+json"
+	apPublic      = "https://www.w3.org/ns/activitystreams#Public"
+	// apUsername is the name the wiki is followed as: @wiki@host.
+	apUsername    = "wiki"
+	apOutboxLimit = 20
+)
+
+// wikiActor publishes page changes to the Fediverse. It is nil unless
+// -activitypub is set.
+var wikiActor *apActor
+
+type apActor struct {
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	followers map[string]string // actor ID -> inbox
+}
+
+func apURL(path string) string {
+	return strings.TrimSuffix(*baseURL, "/") + path
+}
+
+func apActorID() string { return apURL("/ap/actor") }
+
+// setupActivityPub loads or creates the actor's key and loads its
+// followers.
+func setupActivityPub() error {
+	if !*activityPub {
+		return nil
+	}
+	if *baseURL == "" {
+		return errors.New("-activitypub needs -base-url")
+	}
+	key, err := loadActorKey(*apKeyFile)
+	if err != nil {
+		return err
+	}
+	a := &apActor{key: key, followers: make(map[string]string)}
+	b, err := ioutil.ReadFile(*apFollowersFile)
+	if err == nil {
+		err = json.Unmarshal(b, &a.followers)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	wikiActor = a
+	return nil
+}
+
+func loadActorKey(filename string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		return key, ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", filename)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func (a *apActor) saveFollowers() error {
+	b, err := json.Marshal(a.followers)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*apFollowersFile, b, 0600)
+}
+
+func writeActivityJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Handler for /.well-known/webfinger, which lets Fediverse users find the
+// wiki actor as @wiki@host.
+func webfingerHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := url.Parse(*baseURL)
+	subject := "acct:" + apUsername + "@" + u.Host
+	if wikiActor == nil || r.FormValue("resource") != subject {
+		http.NotFound(w, r)
+		return
+	}
+	writeActivityJSON(w, "application/jrd+json", map[string]interface{}{
+		"subject": subject,
+		"links": []map[string]string{
+			{"rel": "self", "type": apContentType, "href": apActorID()},
+		},
+	})
+}
+
+// Handler for /ap/actor.
+func actorHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiActor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&wikiActor.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := apActorID()
+	writeActivityJSON(w, apContentType, map[string]interface{}{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                id,
+		"type":              "Service",
+		"preferredUsername": apUsername,
+		"name":              *wikiName,
+		"url":               apURL("/"),
+		"inbox":             apURL("/ap/inbox"),
+		"outbox":            apURL("/ap/outbox"),
+		"followers":         apURL("/ap/followers"),
+		"publicKey": map[string]string{
+			"id":           id + "#main-key",
+			"owner":        id,
+			"publicKeyPem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		},
+	})
+}
+
+// changeActivity returns a Create activity announcing a change to a Page.
+func changeActivity(title string, mod time.Time, body []byte) map[string]interface{} {
+	id := apURL("/ap/notes/" + title + "/" + strconv.FormatInt(mod.UnixNano(), 10))
+	pageURL := apURL("/view/" + title)
+	content := fmt.Sprintf(`<p><a href="%s">%s</a> was updated.</p>`, html.EscapeString(pageURL), html.EscapeString(title))
+	if e := excerpt(body); e != "" {
+		content += "<p>" + html.EscapeString(e) + "</p>"
+	}
+	published := mod.UTC().Format(time.RFC3339)
+	to, cc := []string{apPublic}, []string{apURL("/ap/followers")}
+	return map[string]interface{}{
+		"@context":  "https://www.w3.org/ns/activitystreams",
+		"id":        id + "/activity",
+		"type":      "Create",
+		"actor":     apActorID(),
+		"published": published,
+		"to":        to,
+		"cc":        cc,
+		"object": map[string]interface{}{
+			"id":           id,
+			"type":         "Note",
+			"attributedTo": apActorID(),
+			"published":    published,
+			"url":          pageURL,
+			"to":           to,
+			"cc":           cc,
+			"content":      content,
+		},
+	}
+}
+
+// Handler for /ap/notes/<title>/<nanos> and the Create activity at
+// /ap/notes/<title>/<nanos>/activity, the IDs changeActivity gives. The
+// wiki keeps no revisions, so a note can only be served while its page
+// is unchanged; once the page has been saved again or deleted, the note
+// is answered with a Tombstone and 410 Gone.
+func noteHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiActor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/ap/notes/"), "/")
+	if len(parts) == 3 && parts[2] == "activity" {
+		parts = parts[:2]
+	} else if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	title := parts[0]
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if !titleValidator.MatchString(title) || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	fi, err := os.Stat(pageFile(title))
+	if err == nil && fi.ModTime().UnixNano() == nanos {
+		p, err := loadPage(title)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		activity := changeActivity(title, fi.ModTime(), p.Body)
+		if strings.HasSuffix(r.URL.Path, "/activity") {
+			writeActivityJSON(w, apContentType, activity)
+		} else {
+			note := activity["object"].(map[string]interface{})
+			note["@context"] = activity["@context"]
+			writeActivityJSON(w, apContentType, note)
+		}
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", apContentType)
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"@context":   "https://www.w3.org/ns/activitystreams",
+		"id":         apURL(r.URL.Path),
+		"type":       "Tombstone",
+		"formerType": "Note",
+		"url":        apURL("/view/" + title),
+	})
+}
+
+// Handler for /ap/outbox, the most recent page changes.
+func outboxHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiActor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	changes, err := recentPages(apOutboxLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var items []interface{}
+	for _, c := range changes {
+		p, err := loadPage(c.Title)
+		if err != nil {
+			continue
+		}
+		items = append(items, changeActivity(c.Title, c.Mod, p.Body))
+	}
+	writeActivityJSON(w, apContentType, map[string]interface{}{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           apURL("/ap/outbox"),
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+// Handler for /ap/followers. Only the number of followers is public.
+func followersHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiActor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	wikiActor.mu.Lock()
+	n := len(wikiActor.followers)
+	wikiActor.mu.Unlock()
+	writeActivityJSON(w, apContentType, map[string]interface{}{
+		"@context":   "https://www.w3.org/ns/activitystreams",
+		"id":         apURL("/ap/followers"),
+		"type":       "OrderedCollection",
+		"totalItems": n,
+	})
+}
+
+// Handler for /ap/inbox. Only Follow and Undo Follow are acted on; every
+// request must carry a valid HTTP signature from the sending actor.
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiActor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sender, err := verifySignature(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var activity struct {
+		Type   string          `json:"type"`
+		Actor  string          `json:"actor"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if activity.Actor != sender.ID {
+		http.Error(w, "actor does not match signature", http.StatusUnauthorized)
+		return
+	}
+	switch activity.Type {
+	case "Follow":
+		wikiActor.mu.Lock()
+		wikiActor.followers[sender.ID] = sender.Inbox
+		err = wikiActor.saveFollowers()
+		wikiActor.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		accept := map[string]interface{}{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"id":       apURL("/ap/accept/" + strconv.FormatInt(time.Now().UnixNano(), 10)),
+			"type":     "Accept",
+			"actor":    apActorID(),
+			"object":   json.RawMessage(body),
+		}
+		if err := enqueue("activitypub", &apDelivery{sender.Inbox, accept}); err != nil {
+			log.Printf("activitypub: accept %s: %v", sender.ID, err)
+		}
+	case "Undo":
+		var object struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(activity.Object, &object)
+		if object.Type == "Follow" {
+			wikiActor.mu.Lock()
+			delete(wikiActor.followers, sender.ID)
+			err = wikiActor.saveFollowers()
+			wikiActor.mu.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func init() {
+	onPageSaved(publishChange)
+	registerTask("activitypub", func(payload json.RawMessage) error {
+		var d struct {
+			Inbox    string
+			Activity json.RawMessage
+		}
+		if err := json.Unmarshal(payload, &d); err != nil {
+			return err
+		}
+		if wikiActor == nil {
+			return errors.New("activitypub is not set up")
+		}
+		return wikiActor.deliver(d.Inbox, d.Activity)
+	})
+}
+
+// An apDelivery is the task of posting an activity to an inbox.
+type apDelivery struct {
+	Inbox    string
+	Activity interface{}
+}
+
+// publishChange queues a Create activity for p to every follower.
+func publishChange(p *Page) {
+	if wikiActor == nil {
+		return
+	}
+	mod := time.Now()
+	if fi, err := os.Stat(pageFile(p.Title)); err == nil {
+		mod = fi.ModTime() // match the outbox entry
+	}
+	activity := changeActivity(p.Title, mod, p.Body)
+	wikiActor.mu.Lock()
+	inboxes := make(map[string]bool)
+	for _, inbox := range wikiActor.followers {
+		inboxes[inbox] = true
+	}
+	wikiActor.mu.Unlock()
+	for inbox := range inboxes {
+		if err := enqueue("activitypub", &apDelivery{inbox, activity}); err != nil {
+			log.Printf("activitypub: deliver to %s: %v", inbox, err)
+		}
+	}
+}
+
+// apClient fetches actors and delivers to inboxes named by other
+// servers, so it may only reach public addresses.
+var apClient = publicClient(15 * time.Second)
+
+// deliver posts a signed activity to inbox.
+func (a *apActor) deliver(inbox string, activity interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", apContentType)
+	if err := a.sign(req, body); err != nil {
+		return err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// sign adds an HTTP signature (draft-cavage-http-signatures) to req,
+// covering the request line, host, date and, when there is a body, its
+// digest.
+func (a *apActor) sign(req *http.Request, body []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+	hash := sha256.Sum256([]byte(signingString(req, req.URL.Host, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		apActorID(), strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+func signingString(r *http.Request, host string, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines[i] = h + ": " + strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			lines[i] = h + ": " + host
+		default:
+			lines[i] = h + ": " + r.Header.Get(h)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A remoteActor is the part of another server's actor document the wiki
+// needs.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// fetchActor retrieves the actor document at id. The request is signed,
+// as some servers refuse anonymous fetches.
+func fetchActor(id string) (*remoteActor, error) {
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", apContentType)
+	if err := wikiActor.sign(req, nil); err != nil {
+		return nil, err
+	}
+	resp, err := apClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", id, resp.Status)
+	}
+	var actor remoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
+
+// verifySignature checks the HTTP signature and digest of an inbox
+// request and returns the actor that signed it.
+func verifySignature(r *http.Request, body []byte) (*remoteActor, error) {
+	params := make(map[string]string)
+	for _, part := range strings.Split(r.Header.Get("Signature"), ",") {
+		if i := strings.Index(part, "="); i > 0 {
+			params[strings.TrimSpace(part[:i])] = strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+		}
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, errors.New("missing signature")
+	}
+	headers := strings.Fields(params["headers"])
+	signed := make(map[string]bool)
+	for _, h := range headers {
+		signed[h] = true
+	}
+	if !signed["(request-target)"] || !signed["date"] || !signed["digest"] {
+		return nil, errors.New("signature must cover (request-target), date and digest")
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("digest mismatch")
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date) > 12*time.Hour || time.Until(date) > time.Hour {
+		return nil, errors.New("bad date")
+	}
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, err
+	}
+	keyID := params["keyId"]
+	actorID := keyID
+	if i := strings.Index(actorID, "#"); i >= 0 {
+		actorID = actorID[:i]
+	}
+	u, err := url.Parse(actorID)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return nil, errors.New("bad keyId")
+	}
+	actor, err := fetchActor(actorID)
+	if err != nil {
+		// Keep the details from the sender, who chose the URL.
+		log.Printf("activitypub: %v", err)
+		return nil, errors.New("cannot fetch the key's actor")
+	}
+	// The document must be the actor it was fetched as, or any server
+	// could speak for any actor. As the actor is the keyId without its
+	// fragment, the key is then on the actor's host.
+	if actor.ID != actorID {
+		return nil, errors.New("actor document is not the key's actor")
+	}
+	if actor.PublicKey.ID != keyID || actor.PublicKey.Owner != actor.ID {
+		return nil, errors.New("key does not belong to actor")
+	}
+	if inbox, err := url.Parse(actor.Inbox); err != nil || inbox.Scheme != "https" && inbox.Scheme != "http" || inbox.Host == "" {
+		return nil, errors.New("bad inbox")
+	}
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, errors.New("bad public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	hash := sha256.Sum256([]byte(signingString(r, r.Host, headers)))
+	if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, errors.New("bad signature")
+	}
+	return actor, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedInbox returns a request to the wiki's inbox carrying body,
+// signed with key as keyID over headers, and dated date.
+func signedInbox(t *testing.T, key *rsa.PrivateKey, keyID string, body []byte, headers []string, date time.Time) *http.Request {
+	r := httptest.NewRequest("POST", "http://wiki.example/ap/inbox", bytes.NewReader(body))
+	r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+	sum := sha256.Sum256(body)
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	hash := sha256.Sum256([]byte(signingString(r, r.Host, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	// The remote server serves actor documents, some of them lying.
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := &remoteActor{ID: srv.URL + r.URL.Path, Inbox: srv.URL + "/inbox"}
+		actor.PublicKey.ID = actor.ID + "#main-key"
+		actor.PublicKey.Owner = actor.ID
+		actor.PublicKey.PublicKeyPem = pub
+		switch r.URL.Path {
+		case "/alice":
+		case "/impostor": // claims to be alice
+			actor.ID = srv.URL + "/alice"
+			actor.PublicKey.Owner = actor.ID
+		case "/borrowed-key": // names alice's key
+			actor.PublicKey.ID = srv.URL + "/alice#main-key"
+		case "/bad-inbox":
+			actor.Inbox = "file:///etc/passwd"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", apContentType)
+		json.NewEncoder(w).Encode(actor)
+	}))
+	defer srv.Close()
+	defer func(actor *apActor, client *http.Client) { wikiActor, apClient = actor, client }(wikiActor, apClient)
+	wikiActor, apClient = &apActor{key: otherKey}, srv.Client()
+
+	body := []byte(`{"type": "Follow"}`)
+	all := []string{"(request-target)", "host", "date", "digest"}
+	now := time.Now()
+	tampered := signedInbox(t, key, srv.URL+"/alice#main-key", body, all, now)
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"type": "Undo"}`))
+	tests := []struct {
+		name string
+		r    *http.Request
+		ok   bool
+	}{
+		{"signed", signedInbox(t, key, srv.URL+"/alice#main-key", body, all, now), true},
+		{"no signature", httptest.NewRequest("POST", "http://wiki.example/ap/inbox", bytes.NewReader(body)), false},
+		{"without digest", signedInbox(t, key, srv.URL+"/alice#main-key", body, all[:3], now), false},
+		{"without date", signedInbox(t, key, srv.URL+"/alice#main-key", body, []string{"(request-target)", "host", "digest"}, now), false},
+		{"old", signedInbox(t, key, srv.URL+"/alice#main-key", body, all, now.Add(-13*time.Hour)), false},
+		{"wrong key", signedInbox(t, otherKey, srv.URL+"/alice#main-key", body, all, now), false},
+		{"unknown actor", signedInbox(t, key, srv.URL+"/bob#main-key", body, all, now), false},
+		{"actor claims another ID", signedInbox(t, key, srv.URL+"/impostor#main-key", body, all, now), false},
+		{"key of another actor", signedInbox(t, key, srv.URL+"/borrowed-key#main-key", body, all, now), false},
+		{"bad inbox", signedInbox(t, key, srv.URL+"/bad-inbox#main-key", body, all, now), false},
+		{"keyId not a web address", signedInbox(t, key, "file:///etc/passwd#main-key", body, all, now), false},
+		{"body changed", tampered, false},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		b.ReadFrom(tt.r.Body)
+		actor, err := verifySignature(tt.r, b.Bytes())
+		if tt.ok && (err != nil || actor.ID != srv.URL+"/alice") {
+			t.Errorf("%s: verifySignature = %v, %v; want alice", tt.name, actor, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: verifySignature = %v, want an error", tt.name, actor)
+		}
+	}
+
+	// The actor is fetched with a client that only reaches public
+	// addresses, so a keyId cannot point the wiki at its own network.
+	apClient = publicClient(time.Second)
+	if _, err := verifySignature(signedInbox(t, key, srv.URL+"/alice#main-key", body, all, now), body); err == nil {
+		t.Errorf("verifySignature fetched an actor on %s", srv.URL)
+	}
+}
+
+func TestNoteHandler(t *testing.T) {
+	testWiki(t, map[string]string{"Page": "one\n"})
+	defer func(actor *apActor, base string) { wikiActor, *baseURL = actor, base }(wikiActor, *baseURL)
+	wikiActor, *baseURL = &apActor{}, "https://wiki.example"
+	fi, err := os.Stat(pageFile("Page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := "/ap/notes/Page/" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		noteHandler(w, httptest.NewRequest("GET", path, nil))
+		var doc map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &doc)
+		return w.Code, doc
+	}
+	tests := []struct {
+		path   string
+		status int
+		typ    string
+	}{
+		{note, http.StatusOK, "Note"},
+		{note + "/activity", http.StatusOK, "Create"},
+		{"/ap/notes/Page/1", http.StatusGone, "Tombstone"},
+		{"/ap/notes/Other/1", http.StatusGone, "Tombstone"},
+		{"/ap/notes/Page/x", http.StatusNotFound, ""},
+		{"/ap/notes/Page", http.StatusNotFound, ""},
+		{note + "/other", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		status, doc := get(tt.path)
+		if status != tt.status || tt.typ != "" && doc["type"] != tt.typ {
+			t.Errorf("GET %s: %d %v, want %d %s", tt.path, status, doc["type"], tt.status, tt.typ)
+		}
+		if tt.status == http.StatusOK && doc["id"] != "https://wiki.example"+tt.path {
+			t.Errorf("GET %s: id %v", tt.path, doc["id"])
+		}
+	}
+
+	// A saved page supersedes the note.
+	later := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(pageFile("Page"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if status, doc := get(note); status != http.StatusGone || doc["type"] != "Tombstone" {
+		t.Errorf("GET %s after a save: %d %v, want 410 Tombstone", note, status, doc["type"])
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/hmac"
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var apiToken = flag.String("api-token", "", "bearer `token` required by the API endpoints that change pages, which are disabled without one")
+
+// apiAuthorized reports whether r carries the -api-token. If not, it
+// replies with an error.
+func apiAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if *apiToken == "" {
+		http.Error(w, "the write API is disabled", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(*apiToken)) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gowiki"`)
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// apiClient describes who made the API request r, for the record. The
+// API has a single token, so it can only tell clients apart by address.
+func apiClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "API client at " + host
+}
+
+// Handler for /api/v1/pages/<title> and /api/v1/pages/<title>/<action>.
+func pagesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/api/v1/pages/")
+	action := ""
+	if i := strings.Index(title, "/"); i >= 0 {
+		title, action = title[:i], title[i+1:]
+	}
+	if !titleValidator.MatchString(title) {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "":
+		pageAPIHandler(w, r, title)
+	case "move":
+		moveAPIHandler(w, r, title)
+	case "restore":
+		restoreAPIHandler(w, r, title)
+	case "duplicate":
+		duplicateAPIHandler(w, r, title)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var (
+	corsOrigins     = flag.String("cors-origins", "", "comma separated `origins` allowed to call the API from a browser, or * for any")
+	corsMethods     = flag.String("cors-methods", "GET, POST, PUT, PATCH, DELETE", "`methods` cross-origin API requests may use")
+	corsCredentials = flag.Bool("cors-credentials", false, "let browsers send credentials with cross-origin API requests")
+)
+
+// corsHeaders are the request headers cross-origin API requests may use.
+const corsHeaders = "Authorization, Content-Type, If-Match"
+
+// corsOrigin returns the value of Access-Control-Allow-Origin for a
+// request from origin, or "" if origin is not allowed.
+func corsOrigin(origin string) string {
+	for _, o := range strings.Split(*corsOrigins, ",") {
+		switch o = strings.TrimSpace(o); {
+		case o == "*" && !*corsCredentials:
+			return "*"
+		case o == "*", strings.EqualFold(o, origin):
+			// Credentials cannot be used with a wildcard, so the
+			// origin is echoed instead.
+			return origin
+		}
+	}
+	return ""
+}
+
+// cors adds the CORS headers allowed by -cors-origins to the responses of
+// fn and answers preflight requests.
+func cors(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allow := ""
+		if origin != "" && *corsOrigins != "" {
+			allow = corsOrigin(origin)
+		}
+		if allow != "" {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", allow)
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Expose-Headers", "ETag")
+			if *corsCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allow != "" {
+				w.Header().Set("Access-Control-Allow-Methods", *corsMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fn(w, r)
+	}
+}
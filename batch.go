@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// batchLimit is the most operations a batch request may hold.
+const batchLimit = 1000
+
+// A pageOp is a change to one page, made through the pages API or as
+// part of a batch.
+type pageOp struct {
+	// Op is "put", which creates or replaces the page with Body,
+	// "delete", which needs a Reason, "tag", which adds Tags to the
+	// page's front matter, or "patch", which applies Diff, a unified
+	// diff, or else Edits.
+	Op    string     `json:"op"`
+	Title string     `json:"title"`
+	Body  string     `json:"body,omitempty"`
+	Tags  []string   `json:"tags,omitempty"`
+	Diff  string     `json:"diff,omitempty"`
+	Edits []pageEdit `json:"edits,omitempty"`
+	// Reason is why the page is deleted, kept in its tombstone.
+	Reason string `json:"reason,omitempty"`
+	// IfMatch, like the If-Match header, makes the operation fail
+	// with 412 unless the page's current ETag is one of those listed.
+	IfMatch string `json:"if_match,omitempty"`
+
+	by string // who asked for the operation, for tombstones
+}
+
+// An opResult reports the outcome of a pageOp with an HTTP status code.
+type opResult struct {
+	Op     string `json:"op"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ETag is the page's ETag after a put or tag.
+	ETag string `json:"etag,omitempty"`
+}
+
+// pageETag returns the entity tag of a page body. It changes whenever
+// the body does, so clients can tell if a page changed since they read
+// it.
+func pageETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// etagMatches reports whether an If-Match value matches the Page p, which
+// is nil if it does not exist.
+func etagMatches(ifMatch string, p *Page) bool {
+	if p == nil {
+		return false
+	}
+	if strings.TrimSpace(ifMatch) == "*" {
+		return true
+	}
+	etag := pageETag(p.Body)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// apply performs op. API clients hold the -api-token and are trusted, so
+// the spam filters are not run; the storage quota and layout checks are.
+func (op *pageOp) apply() opResult {
+	res := opResult{Op: op.Op, Title: op.Title, Status: http.StatusOK}
+	fail := func(status int, err error) opResult {
+		res.Status, res.Error = status, err.Error()
+		return res
+	}
+	if !titleValidator.MatchString(op.Title) {
+		return fail(http.StatusBadRequest, errors.New("bad title"))
+	}
+	// Nothing may change the page between the If-Match check and the write.
+	defer lockPages(op.Title)()
+	current, err := loadPage(op.Title)
+	exists := err == nil
+	if !exists {
+		current = nil
+	}
+	if op.IfMatch != "" && !etagMatches(op.IfMatch, current) {
+		return fail(http.StatusPreconditionFailed, errors.New("the page has changed"))
+	}
+	switch op.Op {
+	case "put", "patch":
+		body := op.Body
+		if op.Op == "patch" {
+			if !exists {
+				return fail(http.StatusNotFound, errors.New("no such page"))
+			}
+			if op.Diff != "" {
+				body, err = applyDiff(string(current.Body), op.Diff)
+			} else {
+				body, err = applyEdits(string(current.Body), op.Edits)
+			}
+			if err != nil {
+				return fail(http.StatusConflict, err)
+			}
+		}
+		p := &Page{Title: op.Title, Body: []byte(body)}
+		if err := checkLayout(p); err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+		if err := savePage(p); err != nil {
+			return fail(saveStatus(err), err)
+		}
+		if !exists {
+			res.Status = http.StatusCreated
+		}
+		res.ETag = pageETag(p.Body)
+	case "delete":
+		if !exists {
+			return fail(http.StatusNotFound, errors.New("no such page"))
+		}
+		if err := trashPage(op.Title, op.Reason, op.by); err == errNoReason {
+			return fail(http.StatusBadRequest, err)
+		} else if err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+	case "tag":
+		if !exists {
+			return fail(http.StatusNotFound, errors.New("no such page"))
+		}
+		p := &Page{Title: op.Title, Body: addTags(current.Body, op.Tags)}
+		if err := savePage(p); err != nil {
+			return fail(saveStatus(err), err)
+		}
+		res.ETag = pageETag(p.Body)
+	default:
+		return fail(http.StatusBadRequest, errors.New("unknown op "+op.Op))
+	}
+	return res
+}
+
+// pageTags returns the tags in the front matter of body, a comma
+// separated list under the tags key.
+func pageTags(body []byte) []string {
+	var tags []string
+	for _, tag := range strings.Split(pageMeta(body)["tags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// addTags returns body with tags added to its front matter.
+func addTags(body []byte, tags []string) []byte {
+	all := pageTags(body)
+	seen := make(map[string]bool)
+	for _, tag := range all {
+		seen[tag] = true
+	}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.NewReplacer(",", " ", "\n", " ").Replace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			all = append(all, tag)
+		}
+	}
+	return setMeta(body, "tags", strings.Join(all, ", "))
+}
+
+// Handler for /api/v1/pages/<title>: GET returns the page, PUT replaces
+// it with the JSON object {"body": "..."}, PATCH changes it and DELETE
+// with ?reason= removes it, leaving a tombstone. PATCH takes a unified
+// diff as text/x-diff, or a JSON object {"edits": [...]} of pageEdits.
+// GET sets an ETag; PUT, PATCH and DELETE with If-Match fail with 412 if
+// the page has changed since.
+func pageAPIHandler(w http.ResponseWriter, r *http.Request, title string) {
+	op := &pageOp{Title: title}
+	switch r.Method {
+	case "GET":
+		p, err := loadPage(title)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", pageETag(p.Body))
+		json.NewEncoder(w).Encode(struct {
+			Title string   `json:"title"`
+			Body  string   `json:"body"`
+			Tags  []string `json:"tags"`
+		}{p.Title, string(p.Body), pageTags(p.Body)})
+		return
+	case "PUT":
+		var req struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		op.Op, op.Body = "put", req.Body
+	case "PATCH":
+		op.Op = "patch"
+		body := http.MaxBytesReader(w, r.Body, 1<<20)
+		if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/x-diff") || strings.HasPrefix(ct, "text/x-patch") {
+			diff, err := ioutil.ReadAll(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			op.Diff = string(diff)
+		} else {
+			var req struct {
+				Edits []pageEdit `json:"edits"`
+			}
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			op.Edits = req.Edits
+		}
+	case "DELETE":
+		op.Op, op.Reason = "delete", r.FormValue("reason")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !apiAuthorized(w, r) {
+		return
+	}
+	op.IfMatch = r.Header.Get("If-Match")
+	op.by = apiClient(r)
+	res := op.apply()
+	w.Header().Set("Content-Type", "application/json")
+	if res.ETag != "" {
+		w.Header().Set("ETag", res.ETag)
+	}
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Handler for POST /api/v1/batch. The request is a JSON object holding a
+// list of operations, {"ops": [{"op": "put", "title": "A", "body": "..."},
+// {"op": "delete", "title": "B"}, {"op": "tag", "title": "C", "tags":
+// ["ops"]}]}. They are applied in order and each gets its own result;
+// one failing does not stop the rest.
+func batchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !apiAuthorized(w, r) {
+		return
+	}
+	var req struct {
+		Ops []pageOp `json:"ops"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 32<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Ops) > batchLimit {
+		http.Error(w, "too many operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+	results := make([]opResult, len(req.Ops))
+	for i := range req.Ops {
+		req.Ops[i].by = apiClient(r)
+		results[i] = req.Ops[i].apply()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Results []opResult `json:"results"`
+	}{results})
+}
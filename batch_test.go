@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testWiki runs the test in an empty wiki in a temporary directory, with
+// the indexes main builds, and writes pages, a map of titles to bodies,
+// into it.
+func testWiki(t *testing.T, pages map[string]string) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for title, body := range pages {
+		if err := ioutil.WriteFile(pageFile(title), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, scan := range []func() error{stats.scan, linkGraph.scan, pageIndex.scan, expiry.scan, linkCheck.scan} {
+		if err := scan(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pageBody returns the body of the page title, or "" if there is none.
+func pageBody(t *testing.T, title string) string {
+	p, err := loadPage(title)
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(p.Body)
+}
+
+func TestETagMatches(t *testing.T) {
+	p := &Page{Title: "Page", Body: []byte("text")}
+	etag := pageETag(p.Body)
+	tests := []struct {
+		ifMatch string
+		p       *Page
+		want    bool
+	}{
+		{etag, p, true},
+		{"*", p, true},
+		{` "other" , ` + etag, p, true},
+		{pageETag([]byte("other text")), p, false},
+		{strings.Trim(etag, `"`), p, false},
+		{"*", nil, false},
+		{etag, nil, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifMatch, tt.p); got != tt.want {
+			t.Errorf("etagMatches(%q, %v) = %v, want %v", tt.ifMatch, tt.p != nil, got, tt.want)
+		}
+	}
+}
+
+func TestApplyIfMatch(t *testing.T) {
+	testWiki(t, map[string]string{"Page": "one\n"})
+	one, two := pageETag([]byte("one\n")), pageETag([]byte("two\n"))
+	// The operations run in order, each on the page the last one left.
+	tests := []struct {
+		op     pageOp
+		status int
+		body   string
+	}{
+		{pageOp{Op: "put", Title: "Page", Body: "two\n", IfMatch: two}, http.StatusPreconditionFailed, "one\n"},
+		{pageOp{Op: "put", Title: "Page", Body: "two\n", IfMatch: one}, http.StatusOK, "two\n"},
+		{pageOp{Op: "put", Title: "Page", Body: "three\n", IfMatch: one}, http.StatusPreconditionFailed, "two\n"},
+		{pageOp{Op: "patch", Title: "Page", Edits: []pageEdit{{Op: "append", Text: "more"}}, IfMatch: one}, http.StatusPreconditionFailed, "two\n"},
+		{pageOp{Op: "tag", Title: "Page", Tags: []string{"ops"}, IfMatch: one}, http.StatusPreconditionFailed, "two\n"},
+		{pageOp{Op: "delete", Title: "Page", Reason: "test", IfMatch: one}, http.StatusPreconditionFailed, "two\n"},
+		{pageOp{Op: "delete", Title: "Page", Reason: "test", IfMatch: two}, http.StatusOK, ""},
+		{pageOp{Op: "put", Title: "Page", Body: "four\n", IfMatch: "*"}, http.StatusPreconditionFailed, ""},
+		{pageOp{Op: "put", Title: "Page", Body: "four\n"}, http.StatusCreated, "four\n"},
+		{pageOp{Op: "put", Title: "Page", Body: "five\n", IfMatch: "*"}, http.StatusOK, "five\n"},
+	}
+	for i, tt := range tests {
+		res := tt.op.apply()
+		if res.Status != tt.status {
+			t.Errorf("%d: %s with If-Match %s: status %d (%s), want %d", i, tt.op.Op, tt.op.IfMatch, res.Status, res.Error, tt.status)
+		}
+		if body := pageBody(t, "Page"); body != tt.body {
+			t.Errorf("%d: %s with If-Match %s: page is %q, want %q", i, tt.op.Op, tt.op.IfMatch, body, tt.body)
+		}
+		if res.Status < 300 && tt.body != "" && res.ETag != pageETag([]byte(tt.body)) {
+			t.Errorf("%d: %s: ETag %s, want that of %q", i, tt.op.Op, res.ETag, tt.body)
+		}
+	}
+}
+
+// TestApplyIfMatchRace checks that of many writers starting from the
+// same version of a page, only one gets to change it.
+func TestApplyIfMatchRace(t *testing.T) {
+	testWiki(t, map[string]string{"Page": "one\n"})
+	etag := pageETag([]byte("one\n"))
+	var wg sync.WaitGroup
+	results := make([]opResult, 100)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			op := &pageOp{Op: "put", Title: "Page", Body: strings.Repeat("x", 1<<16+i), IfMatch: etag}
+			results[i] = op.apply()
+		}(i)
+	}
+	wg.Wait()
+	saved := 0
+	for _, res := range results {
+		if res.Status == http.StatusOK {
+			saved++
+		} else if res.Status != http.StatusPreconditionFailed {
+			t.Errorf("status %d (%s), want 200 or 412", res.Status, res.Error)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("%d writers changed the page, want 1", saved)
+	}
+}
+
+func TestPageAPIIfMatch(t *testing.T) {
+	testWiki(t, map[string]string{"Page": "one\n"})
+	defer func(token string) { *apiToken = token }(*apiToken)
+	*apiToken = "secret"
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/pages/Page", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		pageAPIHandler(w, r, "Page")
+		return w
+	}
+	etag := do("GET", "", "").Header().Get("ETag")
+	if etag != pageETag([]byte("one\n")) {
+		t.Fatalf("GET: ETag %q, want that of the page", etag)
+	}
+	tests := []struct {
+		method, body, ifMatch string
+		status                int
+	}{
+		{"PUT", `{"body": "two\n"}`, etag, http.StatusOK},
+		{"PUT", `{"body": "three\n"}`, etag, http.StatusPreconditionFailed},
+		{"DELETE", "", etag, http.StatusPreconditionFailed},
+		{"PUT", `{"body": "three\n"}`, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := do(tt.method, tt.body, tt.ifMatch)
+		if w.Code != tt.status {
+			t.Errorf("%s %s with If-Match %s: status %d, want %d", tt.method, tt.body, tt.ifMatch, w.Code, tt.status)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("ETag") != pageETag([]byte(pageBody(t, "Page"))) {
+			t.Errorf("%s %s: ETag %s is not that of the page", tt.method, tt.body, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var blockListFile = flag.String("blocklist", "", "`file` of IPs, CIDRs and ua: patterns that may not edit")
+
+// blocks is the block list read from -blocklist.
+var blocks = &blockList{}
+
+// A block matches a client by network or by User-Agent substring. A zero
+// expires never expires.
+type block struct {
+	network *net.IPNet
+	agent   string
+	expires time.Time
+	line    string
+}
+
+// blockList holds the parsed block list file. It is re-read whenever
+// the file changes, so the list can be maintained while the wiki is
+// running.
+//
+// Each line holds one entry followed by an optional expiry date:
+//
+//	203.0.113.7
+//	198.51.100.0/24 2030-01-02
+//	ua:EvilBot 2030-01-02T15:04:05Z
+type blockList struct {
+	mu      sync.Mutex
+	modTime time.Time
+	blocks  []block
+}
+
+// reload re-reads filename if it has been modified since the last read.
+func (l *blockList) reload(filename string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(l.modTime) {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var list []block
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		b, err := parseBlock(s.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		if b != nil {
+			list = append(list, *b)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	l.blocks, l.modTime = list, fi.ModTime()
+	return nil
+}
+
+// parseBlock parses one line of the block list. Blank lines and
+// comments return nil.
+func parseBlock(line string) (*block, error) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("too many fields")
+	}
+	b := &block{line: strings.Join(fields, " ")}
+	if len(fields) == 2 {
+		t, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", fields[1]); err != nil {
+				return nil, fmt.Errorf("bad expiry %q", fields[1])
+			}
+		}
+		b.expires = t
+	}
+	entry := fields[0]
+	switch {
+	case strings.HasPrefix(entry, "ua:"):
+		b.agent = strings.ToLower(entry[len("ua:"):])
+	case strings.Contains(entry, "/"):
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		b.network = network
+	default:
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("bad address %q", entry)
+		}
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		b.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	return b, nil
+}
+
+// match returns the entry blocking r, if any.
+func (l *blockList) match(r *http.Request) (*block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(*blockListFile); err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	agent := strings.ToLower(r.UserAgent())
+	now := time.Now()
+	for i := range l.blocks {
+		b := &l.blocks[i]
+		if !b.expires.IsZero() && now.After(b.expires) {
+			continue
+		}
+		if b.network != nil && ip != nil && b.network.Contains(ip) ||
+			b.agent != "" && strings.Contains(agent, b.agent) {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkBlocked wraps a handler so that clients on the block list are
+// turned away with 403 Forbidden.
+func checkBlocked(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *blockListFile != "" {
+			b, err := blocks.match(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if b != nil {
+				log.Printf("blocked %s %s from %s (%q): %s", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), b.line)
+				http.Error(w, tr(r, "You have been blocked from editing this wiki."), http.StatusForbidden)
+				return
+			}
+		}
+		fn(w, r)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseBlock(t *testing.T) {
+	tests := []struct {
+		line    string
+		network string
+		agent   string
+		expires time.Time
+		nothing bool
+		err     bool
+	}{
+		{line: "", nothing: true},
+		{line: "   # a comment", nothing: true},
+		{line: "203.0.113.7", network: "203.0.113.7/32"},
+		{line: "2001:db8::1", network: "2001:db8::1/128"},
+		{line: "198.51.100.0/24 2030-01-02", network: "198.51.100.0/24", expires: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{line: "198.51.100.7/24", network: "198.51.100.0/24"},
+		{line: "ua:EvilBot 2030-01-02T15:04:05Z # scraper", agent: "evilbot", expires: time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{line: "203.0.113.300", err: true},
+		{line: "198.51.100.0/33", err: true},
+		{line: "203.0.113.7 tomorrow", err: true},
+		{line: "203.0.113.7 2030-01-02 extra", err: true},
+	}
+	for _, tt := range tests {
+		b, err := parseBlock(tt.line)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseBlock(%q) = %+v, want an error", tt.line, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBlock(%q): %v", tt.line, err)
+			continue
+		}
+		if tt.nothing {
+			if b != nil {
+				t.Errorf("parseBlock(%q) = %+v, want nil", tt.line, b)
+			}
+			continue
+		}
+		network := ""
+		if b.network != nil {
+			network = b.network.String()
+		}
+		if network != tt.network || b.agent != tt.agent || !b.expires.Equal(tt.expires) {
+			t.Errorf("parseBlock(%q) = network %q, agent %q, expires %v; want %q, %q, %v",
+				tt.line, network, b.agent, b.expires, tt.network, tt.agent, tt.expires)
+		}
+	}
+}
+
+func TestBlockListMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "blocklist")
+	list := "203.0.113.7\n198.51.100.0/24\n192.0.2.1 2001-01-01\nua:EvilBot\n"
+	if err := ioutil.WriteFile(file, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { *blockListFile = old }(*blockListFile)
+	*blockListFile = file
+	l := &blockList{}
+	tests := []struct {
+		addr, agent string
+		blocked     string
+	}{
+		{"203.0.113.7:1234", "Firefox", "203.0.113.7"},
+		{"198.51.100.99:1234", "Firefox", "198.51.100.0/24"},
+		{"203.0.113.8:1234", "Firefox", ""},
+		{"192.0.2.1:1234", "Firefox", ""}, // expired
+		{"203.0.113.8:1234", "Mozilla/5.0 (compatible; evilbot/2.1)", "ua:EvilBot"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/save/FrontPage", nil)
+		r.RemoteAddr = tt.addr
+		r.Header.Set("User-Agent", tt.agent)
+		b, err := l.match(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if b != nil {
+			got = b.line
+		}
+		if got != tt.blocked {
+			t.Errorf("match(%s, %q) = %q, want %q", tt.addr, tt.agent, got, tt.blocked)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var journalPrefix = flag.String("journal-prefix", "Journal", "title `prefix` of the daily pages the calendar links to, followed by the date as in Journal20260131")
+
+// A calendarDay is one day of a month's calendar.
+type calendarDay struct {
+	Date    time.Time
+	InMonth bool
+	Today   bool
+	Pages   []string
+}
+
+// journalTitle returns the title of the journal page of day.
+func journalTitle(day time.Time) string {
+	return *journalPrefix + day.Format("20060102")
+}
+
+// pageDate returns the date the calendar shows the page title with the
+// front matter meta on, and whether it has one. A page is dated by a
+// "date" key in its front matter, as in date: 2026-01-31, for meetings
+// and events, or by its title if it is a daily journal page named
+// -journal-prefix and the date, as in Journal20260131.
+func pageDate(title string, meta map[string]string) (time.Time, bool) {
+	if d := meta["date"]; d != "" {
+		if t, err := time.Parse("2006-01-02", d); err == nil {
+			return t, true
+		}
+	}
+	if *journalPrefix != "" && strings.HasPrefix(title, *journalPrefix) {
+		if t, err := time.Parse("20060102", strings.TrimPrefix(title, *journalPrefix)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// datedPages returns the titles of the dated pages between from and to,
+// by day as in 2006-01-02. The front matter comes from pageIndex.
+func datedPages(from, to time.Time) map[string][]string {
+	days := make(map[string][]string)
+	for _, qp := range pageIndex.all() {
+		if d, ok := pageDate(qp.title, qp.meta); ok && !d.Before(from) && d.Before(to) {
+			key := d.Format("2006-01-02")
+			days[key] = append(days[key], qp.title)
+		}
+	}
+	return days
+}
+
+// calendarWeeks returns the weeks, Monday to Sunday, of the month that
+// month is in, with the dated pages of each day. today is marked.
+func calendarWeeks(month, today time.Time) [][]calendarDay {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := first.AddDate(0, 0, -((int(first.Weekday()) + 6) % 7))
+	next := first.AddDate(0, 1, 0)
+	end := next.AddDate(0, 0, (7-int(next.Weekday())+1)%7)
+	pages := datedPages(start, end)
+	todayKey := today.Format("2006-01-02")
+	var weeks [][]calendarDay
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 7) {
+		week := make([]calendarDay, 7)
+		for i := range week {
+			day := d.AddDate(0, 0, i)
+			key := day.Format("2006-01-02")
+			week[i] = calendarDay{Date: day, InMonth: day.Month() == first.Month(), Today: key == todayKey, Pages: pages[key]}
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
+
+// parseMonth parses a month as in 2006-01, or returns the month of now if
+// s is empty.
+func parseMonth(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	t, err := time.Parse("2006-01", s)
+	if err != nil {
+		return time.Time{}, newUserError("bad month %q", s)
+	}
+	return t, nil
+}
+
+// Handler for /calendar?month=2006-01, which shows the dated pages of a
+// month, this month by default, with a link to today's journal page.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(viewerLocation(r))
+	month, err := parseMonth(r.FormValue("month"), now)
+	if err != nil {
+		http.Error(w, errorText(r, err), http.StatusBadRequest)
+		return
+	}
+	weeks := calendarWeeks(month, now)
+	data := struct {
+		Month, Prev, Next time.Time
+		Weeks             [][]calendarDay
+		TodayPage         string
+		JournalTemplate   string
+	}{month, month.AddDate(0, -1, 0), month.AddDate(0, 1, 0), weeks, journalTitle(now), ""}
+	if hasTemplate(*journalPrefix) {
+		data.JournalTemplate = *journalPrefix
+	}
+	renderTemplate(w, r, "calendar", data)
+}
+
+func init() {
+	registerMacro("calendar", calendarMacro)
+}
+
+// calendarMacro is the calendar macro. {{calendar}} shows this month's
+// dated pages in a table, and {{calendar 2006-01}} those of another
+// month.
+func calendarMacro(args []string) (template.HTML, error) {
+	if len(args) > 1 {
+		return "", errors.New("want {{calendar}} or {{calendar 2006-01}}")
+	}
+	now := time.Now()
+	var s string
+	if len(args) == 1 {
+		s = args[0]
+	}
+	month, err := parseMonth(s, now)
+	if err != nil {
+		return "", err
+	}
+	weeks := calendarWeeks(month, now)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<table class="calendar"><caption>%s</caption><tr>`, month.Format("January 2006"))
+	for _, d := range weeks[0] {
+		fmt.Fprintf(&b, "<th>%s</th>", d.Date.Format("Mon"))
+	}
+	b.WriteString("</tr>")
+	for _, week := range weeks {
+		b.WriteString("<tr>")
+		for _, d := range week {
+			class := ""
+			if d.Today {
+				class = ` class="today"`
+			} else if !d.InMonth {
+				class = ` class="other-month"`
+			}
+			fmt.Fprintf(&b, "<td%s>%d", class, d.Date.Day())
+			for _, title := range d.Pages {
+				fmt.Fprintf(&b, `<br><a href="/view/%s">%s</a>`, title, title)
+			}
+			b.WriteString("</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+	return template.HTML(b.String()), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Preview cards use the size recommended for og:image.
+const (
+	cardWidth  = 1200
+	cardHeight = 630
+	cardMargin = 80
+)
+
+var (
+	cardBackground = color.RGBA{0x1f, 0x2a, 0x38, 0xff}
+	cardAccent     = color.RGBA{0x4f, 0x9d, 0xde, 0xff}
+	cardText       = color.RGBA{0xf5, 0xf5, 0xf5, 0xff}
+)
+
+// cards caches the rendered card of each Page, keyed by the modification
+// time of its file, so a card is drawn once per revision.
+var cards = struct {
+	sync.Mutex
+	m map[string]renderedCard
+}{m: make(map[string]renderedCard)}
+
+type renderedCard struct {
+	mod time.Time
+	png []byte
+}
+
+// Handler to serve the preview card of a wiki Page.
+func cardHandler(w http.ResponseWriter, r *http.Request, title string) {
+	fi, err := os.Stat(pageFile(title))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	cards.Lock()
+	c, ok := cards.m[title]
+	cards.Unlock()
+	if !ok || !c.mod.Equal(fi.ModTime()) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, drawCard(title, *wikiName)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c = renderedCard{mod: fi.ModTime(), png: buf.Bytes()}
+		cards.Lock()
+		cards.m[title] = c
+		cards.Unlock()
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, c.mod.UnixNano()))
+	http.ServeContent(w, r, "", c.mod, bytes.NewReader(c.png))
+}
+
+// drawCard draws title, split into words at case changes, above the
+// name of the wiki.
+func drawCard(title, name string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{cardBackground}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, cardHeight-24, cardWidth, cardHeight), &image.Uniform{cardAccent}, image.Point{}, draw.Src)
+
+	words := splitTitle(title)
+	for _, scale := range []int{16, 12, 9, 6} {
+		lines := wrapWords(words, (cardWidth-2*cardMargin)/(glyphAdvance*scale))
+		if len(lines)*glyphLine*scale <= cardHeight-3*cardMargin {
+			for i, line := range lines {
+				drawText(img, line, cardMargin, cardMargin+i*glyphLine*scale, scale, cardText)
+			}
+			break
+		}
+	}
+	if max := (cardWidth - 2*cardMargin) / (glyphAdvance * 5); len(name) > max {
+		name = name[:max]
+	}
+	drawText(img, name, cardMargin, cardHeight-cardMargin-glyphHeight*5, 5, cardAccent)
+	return img
+}
+
+// splitTitle splits a CamelCase title into words.
+func splitTitle(title string) []string {
+	var words []string
+	start := 0
+	runes := []rune(title)
+	for i := 1; i < len(runes); i++ {
+		prev, r := runes[i-1], runes[i]
+		if unicode.IsUpper(r) && !unicode.IsUpper(prev) ||
+			unicode.IsDigit(r) != unicode.IsDigit(prev) ||
+			unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// wrapWords fills lines of at most width characters. Words longer than a
+// line are cut.
+func wrapWords(words []string, width int) []string {
+	var lines []string
+	line := ""
+	for _, w := range words {
+		for len(w) > width {
+			if line != "" {
+				lines, line = append(lines, line), ""
+			}
+			lines, w = append(lines, w[:width]), w[width:]
+		}
+		switch {
+		case line == "":
+			line = w
+		case len(line)+1+len(w) <= width:
+			line += " " + w
+		default:
+			lines, line = append(lines, line), w
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawText draws s with its top left corner at x, y, each font pixel
+// scale pixels wide. The font only has capitals, digits and some
+// punctuation; other characters are drawn as '?'.
+func drawText(img draw.Image, s string, x, y, scale int, c color.Color) {
+	src := &image.Uniform{c}
+	for _, r := range strings.ToUpper(s) {
+		g, ok := glyphs[r]
+		if !ok {
+			g = glyphs['?']
+		}
+		for row, bits := range g {
+			for col, b := range bits {
+				if b == '#' {
+					px := x + col*scale
+					py := y + row*scale
+					draw.Draw(img, image.Rect(px, py, px+scale, py+scale), src, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// The card font is a 5x7 bitmap font.
+const (
+	glyphHeight  = 7
+	glyphAdvance = 6 // width plus spacing
+	glyphLine    = 10
+)
+
+var glyphs = map[rune][glyphHeight]string{
+	' ':  {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+	'A':  {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B':  {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C':  {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D':  {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E':  {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F':  {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G':  {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H':  {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I':  {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J':  {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K':  {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L':  {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M':  {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N':  {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O':  {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P':  {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q':  {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R':  {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S':  {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T':  {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U':  {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V':  {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W':  {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X':  {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y':  {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z':  {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'0':  {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1':  {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2':  {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3':  {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4':  {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5':  {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6':  {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7':  {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8':  {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9':  {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'.':  {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	',':  {".....", ".....", ".....", ".....", ".##..", "..#..", ".#..."},
+	':':  {".....", ".##..", ".##..", ".....", ".##..", ".##..", "....."},
+	'-':  {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'_':  {".....", ".....", ".....", ".....", ".....", ".....", "#####"},
+	'\'': {"..#..", "..#..", ".....", ".....", ".....", ".....", "....."},
+	'!':  {"..#..", "..#..", "..#..", "..#..", "..#..", ".....", "..#.."},
+	'?':  {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	challengeKind    = flag.String("challenge", "", "require a `kind` of challenge before saving: pow, hcaptcha or turnstile")
+	challengeSiteKey = flag.String("challenge-sitekey", "", "site key for the hcaptcha or turnstile challenge")
+	challengeSecret  = flag.String("challenge-secret", "", "secret key for the hcaptcha or turnstile challenge")
+	powDifficulty    = flag.Int("pow-difficulty", 16, "leading zero bits required by the pow challenge")
+)
+
+// saveChallenge is checked by saveHandler before a Page is written.
+// It is nil when no challenge is configured.
+var saveChallenge challenge
+
+var errChallengeFailed = errors.New("challenge failed, please try again")
+
+// A challenge is something a client has to solve before a save is
+// accepted. It stops automated spam without getting in the way of people.
+type challenge interface {
+	// Widget returns the markup embedded in the edit form.
+	Widget() (template.HTML, error)
+	// Verify checks the answer submitted along with the form.
+	Verify(r *http.Request) error
+}
+
+// newChallenge returns the challenge selected by kind, or nil for none.
+func newChallenge(kind string) (challenge, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "pow":
+		if *powDifficulty < 1 || *powDifficulty > 32 {
+			return nil, fmt.Errorf("pow difficulty must be between 1 and 32")
+		}
+		return newProofOfWork(*powDifficulty, time.Hour)
+	case "hcaptcha":
+		return newSiteVerify(kind, "https://js.hcaptcha.com/1/api.js", "h-captcha",
+			"h-captcha-response", "https://api.hcaptcha.com/siteverify")
+	case "turnstile":
+		return newSiteVerify(kind, "https://challenges.cloudflare.com/turnstile/v0/api.js", "cf-turnstile",
+			"cf-turnstile-response", "https://challenges.cloudflare.com/turnstile/v0/siteverify")
+	}
+	return nil, fmt.Errorf("unknown challenge %q", kind)
+}
+
+// challengeWidget is the template function used by edit.html.
+func challengeWidget() (template.HTML, error) {
+	if saveChallenge == nil {
+		return "", nil
+	}
+	return saveChallenge.Widget()
+}
+
+// proofOfWork asks the browser to find a nonce such that
+// sha256(seed + ":" + nonce) starts with a number of zero bits.
+// Seeds are signed so the server does not have to remember them until
+// they are spent.
+type proofOfWork struct {
+	difficulty int
+	ttl        time.Duration
+	key        []byte
+
+	mu    sync.Mutex
+	spent map[string]time.Time
+}
+
+func newProofOfWork(difficulty int, ttl time.Duration) (*proofOfWork, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &proofOfWork{difficulty: difficulty, ttl: ttl, key: key, spent: make(map[string]time.Time)}, nil
+}
+
+func (c *proofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *proofOfWork) Widget() (template.HTML, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(nonce) + "." + strconv.FormatInt(time.Now().Unix(), 10)
+	var buf bytes.Buffer
+	err := powWidget.Execute(&buf, struct {
+		Seed       string
+		Difficulty int
+	}{payload + "." + c.sign(payload), c.difficulty})
+	return template.HTML(buf.String()), err
+}
+
+func (c *proofOfWork) Verify(r *http.Request) error {
+	seed, nonce := r.FormValue("pow-seed"), r.FormValue("pow-nonce")
+	i := strings.LastIndex(seed, ".")
+	if i < 0 || !hmac.Equal([]byte(seed[i+1:]), []byte(c.sign(seed[:i]))) {
+		return errChallengeFailed
+	}
+	issued, err := strconv.ParseInt(seed[strings.LastIndex(seed[:i], ".")+1:i], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > c.ttl {
+		return errChallengeFailed
+	}
+	sum := sha256.Sum256([]byte(seed + ":" + nonce))
+	if leadingZeroBits(sum[:]) < c.difficulty {
+		return errChallengeFailed
+	}
+
+	// A solved seed may only be used once.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for s, t := range c.spent {
+		if now.Sub(t) > c.ttl {
+			delete(c.spent, s)
+		}
+	}
+	if _, ok := c.spent[seed]; ok {
+		return errChallengeFailed
+	}
+	c.spent[seed] = time.Unix(issued, 0)
+	return nil
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// The solver runs when the form is submitted. crypto.subtle is only
+// available on secure origins, so a small SHA-256 is included instead.
+var powWidget = template.Must(template.New("pow").Parse(`<input type="hidden" name="pow-seed" value="{{.Seed}}">
+<input type="hidden" name="pow-nonce" value="">
+<script>
+(function (form, difficulty) {
+	var K = [], H = [];
+	for (var c = 2, primes = []; primes.length < 64; c++) {
+		if (primes.every(function (p) { return c % p; })) {
+			if (primes.length < 8) H.push((Math.pow(c, 1 / 2) * 4294967296) | 0);
+			K.push((Math.pow(c, 1 / 3) * 4294967296) | 0);
+			primes.push(c);
+		}
+	}
+	function rr(v, n) { return (v >>> n) | (v << (32 - n)); }
+	function sha256(s) {
+		var words = [], n = s.length * 8, h = H.slice(0);
+		s += "\x80";
+		while (s.length % 64 != 56) s += "\x00";
+		for (var i = 0; i < s.length; i++) words[i >> 2] |= s.charCodeAt(i) << (3 - (i & 3)) * 8;
+		words.push((n / 4294967296) | 0, n);
+		for (var j = 0; j < words.length; j += 16) {
+			var w = words.slice(j, j + 16), old = h.slice(0, 8);
+			for (i = 0; i < 64; i++) {
+				if (i >= 16) {
+					var w15 = w[i - 15], w2 = w[i - 2];
+					w[i] = (w[i - 16] + (rr(w15, 7) ^ rr(w15, 18) ^ (w15 >>> 3)) + w[i - 7] + (rr(w2, 17) ^ rr(w2, 19) ^ (w2 >>> 10))) | 0;
+				}
+				var a = h[0], e = h[4];
+				var t1 = h[7] + (rr(e, 6) ^ rr(e, 11) ^ rr(e, 25)) + ((e & h[5]) ^ (~e & h[6])) + K[i] + w[i];
+				var t2 = (rr(a, 2) ^ rr(a, 13) ^ rr(a, 22)) + ((a & h[1]) ^ (a & h[2]) ^ (h[1] & h[2]));
+				h = [(t1 + t2) | 0].concat(h.slice(0, 7));
+				h[4] = (h[4] + t1) | 0;
+			}
+			for (i = 0; i < 8; i++) h[i] = (h[i] + old[i]) | 0;
+		}
+		return h;
+	}
+	function solved(h) {
+		for (var i = 0, d = difficulty; d > 0; i++, d -= 32) {
+			if (d >= 32 ? h[i] : h[i] >>> (32 - d)) return false;
+		}
+		return true;
+	}
+	form.addEventListener("submit", function () {
+		var seed = form.elements["pow-seed"].value, nonce = 0;
+		while (!solved(sha256(seed + ":" + nonce))) nonce++;
+		form.elements["pow-nonce"].value = nonce;
+	});
+})(document.currentScript.closest("form"), {{.Difficulty}});
+</script>`))
+
+// siteVerify checks answers with a hosted CAPTCHA service. hCaptcha and
+// Cloudflare Turnstile share the same widget and siteverify API shape.
+type siteVerify struct {
+	script, class, field, verifyURL string
+	siteKey, secret                 string
+	client                          *http.Client
+}
+
+func newSiteVerify(kind, script, class, field, verifyURL string) (*siteVerify, error) {
+	if *challengeSiteKey == "" || *challengeSecret == "" {
+		return nil, fmt.Errorf("the %s challenge needs -challenge-sitekey and -challenge-secret", kind)
+	}
+	return &siteVerify{
+		script:    script,
+		class:     class,
+		field:     field,
+		verifyURL: verifyURL,
+		siteKey:   *challengeSiteKey,
+		secret:    *challengeSecret,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (c *siteVerify) Widget() (template.HTML, error) {
+	var buf bytes.Buffer
+	err := siteVerifyWidget.Execute(&buf, map[string]string{
+		"Script":  c.script,
+		"Class":   c.class,
+		"SiteKey": c.siteKey,
+	})
+	return template.HTML(buf.String()), err
+}
+
+func (c *siteVerify) Verify(r *http.Request) error {
+	form := url.Values{"secret": {c.secret}, "response": {r.FormValue(c.field)}}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		form.Set("remoteip", host)
+	}
+	resp, err := c.client.PostForm(c.verifyURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return errChallengeFailed
+	}
+	return nil
+}
+
+var siteVerifyWidget = template.Must(template.New("siteverify").Parse(`<script src="{{.Script}}" async defer></script>
+<div class="{{.Class}}" data-sitekey="{{.SiteKey}}"></div>`))
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x40}, 9},
+		{[]byte{0x00, 0x00}, 16},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.b); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.b, got, tt.want)
+		}
+	}
+}
+
+// powSeed returns a seed signed by c as if issued at issued.
+func powSeed(c *proofOfWork, issued time.Time) string {
+	payload := "bm9uY2U." + strconv.FormatInt(issued.Unix(), 10)
+	return payload + "." + c.sign(payload)
+}
+
+// findNonce returns the first nonce that solves seed for c, or that
+// does not if solved is false.
+func findNonce(c *proofOfWork, seed string, solved bool) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		sum := sha256.Sum256([]byte(seed + ":" + nonce))
+		if (leadingZeroBits(sum[:]) >= c.difficulty) == solved {
+			return nonce
+		}
+	}
+}
+
+func powRequest(seed, nonce string) *http.Request {
+	form := url.Values{"pow-seed": {seed}, "pow-nonce": {nonce}}
+	r := httptest.NewRequest("POST", "/save/FrontPage", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestProofOfWorkVerify(t *testing.T) {
+	c, err := newProofOfWork(8, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newProofOfWork(8, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := powSeed(c, time.Now())
+	unsolved := powSeed(c, time.Now().Add(-time.Minute))
+	stale := powSeed(c, time.Now().Add(-2*time.Hour))
+	forged := powSeed(other, time.Now())
+	tests := []struct {
+		name        string
+		seed, nonce string
+		ok          bool
+	}{
+		{"solved", fresh, findNonce(c, fresh, true), true},
+		{"replayed", fresh, findNonce(c, fresh, true), false},
+		{"unsolved", unsolved, findNonce(c, unsolved, false), false},
+		{"expired", stale, findNonce(c, stale, true), false},
+		{"signed by another key", forged, findNonce(c, forged, true), false},
+		{"tampered", strings.Replace(fresh, "bm9uY2U", "bm9uY2X", 1), "0", false},
+		{"no signature", "bm9uY2U", "0", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		err := c.Verify(powRequest(tt.seed, tt.nonce))
+		if tt.ok && err != nil {
+			t.Errorf("%s: Verify = %v, want nil", tt.name, err)
+		} else if !tt.ok && err != errChallengeFailed {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, errChallengeFailed)
+		}
+	}
+}
+
+func TestSiteVerify(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "s3cret" {
+			http.Error(w, "bad secret", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("response") == "good" {
+			w.Write([]byte(`{"success": true}`))
+		} else {
+			w.Write([]byte(`{"success": false}`))
+		}
+	}))
+	defer srv.Close()
+	c := &siteVerify{field: "h-captcha-response", verifyURL: srv.URL, secret: "s3cret", client: srv.Client()}
+	tests := []struct {
+		response string
+		ok       bool
+	}{
+		{"good", true},
+		{"bad", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		form := url.Values{"h-captcha-response": {tt.response}}
+		r := httptest.NewRequest("POST", "/save/FrontPage", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := c.Verify(r); (err == nil) != tt.ok {
+			t.Errorf("Verify with %q = %v, want ok %v", tt.response, err, tt.ok)
+		}
+	}
+}
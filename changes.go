@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var changesDir = flag.String("changes-dir", "changes", "`dir` the changes linked from notifications are kept in")
+
+// changeKeep is how long a change stays viewable after it was made.
+const changeKeep = 30 * 24 * time.Hour
+
+// A pageChange is the diff of one save of a page, kept so notifications
+// can link to what changed. The wiki keeps no revisions, so only changes
+// that were notified are kept, and only for changeKeep.
+type pageChange struct {
+	Title string
+	Time  time.Time
+	Hunks []byte // the diff hunks as JSON, sealed like page bodies
+}
+
+var changeID = regexp.MustCompile(`^[a-zA-Z0-9]+-[0-9]+$`)
+
+func changeFile(id string) string {
+	return filepath.Join(*changesDir, id+".json")
+}
+
+// recordChange keeps the diff between the bodies before and after of the
+// page title and returns its ID, as in Title-1700000000000000000.
+func recordChange(title string, before, after []byte) (string, error) {
+	hunks, err := json.Marshal(diffHunks(diffBodies(splitLines(string(before)), splitLines(string(after)))))
+	if err != nil {
+		return "", err
+	}
+	if hunks, err = sealBody(hunks); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	b, err := json.Marshal(&pageChange{title, now.UTC(), hunks})
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(*changesDir, 0700); err != nil {
+		return "", err
+	}
+	id := title + "-" + strconv.FormatInt(now.UnixNano(), 10)
+	return id, ioutil.WriteFile(changeFile(id), b, 0600)
+}
+
+// loadChange returns the change id and its hunks.
+func loadChange(id string) (*pageChange, []diffHunk, error) {
+	b, err := ioutil.ReadFile(changeFile(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	var c pageChange
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", id, err)
+	}
+	plain, err := openBody(c.Hunks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", id, err)
+	}
+	var hunks []diffHunk
+	if err := json.Unmarshal(plain, &hunks); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", id, err)
+	}
+	return &c, hunks, nil
+}
+
+// expireChanges removes the changes older than changeKeep.
+func expireChanges() error {
+	files, err := filepath.Glob(filepath.Join(*changesDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), ".json")
+		if !changeID.MatchString(id) {
+			continue
+		}
+		nanos, _ := strconv.ParseInt(id[strings.LastIndex(id, "-")+1:], 10, 64)
+		if time.Since(time.Unix(0, nanos)) > changeKeep {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler for /change/<id>, which shows a change a notification linked
+// to with diff.html.
+func changeHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/change/")
+	if !changeID.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+	c, hunks, err := loadChange(id)
+	if os.IsNotExist(err) {
+		http.Error(w, tr(r, "this change is no longer kept"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, h := range hunks {
+		markWords(h.Lines)
+	}
+	renderTemplate(w, r, "diff", struct {
+		From, To string
+		Hunks    []diffHunk
+		Changed  time.Time
+	}{c.Title, c.Title, hunks, c.Time})
+}
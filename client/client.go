@@ -0,0 +1,340 @@
+// Package client is a Go client for the gowiki API described in
+// openapi.yaml at the root of the repository.
+//
+//	c := client.New("http://localhost:8080", os.Getenv("WIKI_TOKEN"))
+//	p, err := c.GetPage("FrontPage")
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the API version the client speaks.
+const Version = "v1"
+
+// A Client talks to one wiki.
+type Client struct {
+	// BaseURL is the URL of the wiki, such as http://localhost:8080.
+	BaseURL string
+	// Token is the wiki's -api-token. Only calls that change pages
+	// need it.
+	Token string
+	// HTTPClient makes the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the wiki at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// A Page is a wiki page.
+type Page struct {
+	Title string   `json:"title"`
+	Body  string   `json:"body"`
+	Tags  []string `json:"tags"`
+	// ETag identifies this version of the page, for PutPageIfMatch.
+	ETag string `json:"-"`
+}
+
+// An Edit is one step of a patch: "append" adds Text to the end of the
+// page, or of the section under Heading, "replace" replaces the text of
+// the section under Heading and "insert_after" inserts Text after the
+// first line holding Anchor.
+type Edit struct {
+	Op      string `json:"op"`
+	Heading string `json:"heading,omitempty"`
+	Anchor  string `json:"anchor,omitempty"`
+	Text    string `json:"text"`
+}
+
+// An Op is one operation of a batch: "put" creates or replaces Title
+// with Body, "delete" removes it for Reason, "tag" adds Tags to it and
+// "patch" applies Diff, a unified diff, or else Edits to it.
+type Op struct {
+	Op    string   `json:"op"`
+	Title string   `json:"title"`
+	Body  string   `json:"body,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Diff  string   `json:"diff,omitempty"`
+	Edits []Edit   `json:"edits,omitempty"`
+	// Reason is why the page is deleted. Deletes need one.
+	Reason string `json:"reason,omitempty"`
+	// IfMatch makes the operation fail with 412 unless the page still
+	// has this ETag.
+	IfMatch string `json:"if_match,omitempty"`
+}
+
+// A Result is the outcome of one operation, with the HTTP status it
+// would have had as a request of its own.
+type Result struct {
+	Op     string `json:"op"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+// An Event reports a change to a page. Type is "save", "delete" or
+// "move", for which From is the old title.
+type Event struct {
+	Cursor string    `json:"cursor"`
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	From   string    `json:"from,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Changes is the answer to Watch.
+type Changes struct {
+	// Cursor is passed to the next Watch.
+	Cursor string `json:"cursor"`
+	// Reset is true if changes may have been missed, because the
+	// cursor was empty or too old or the server restarted.
+	Reset  bool    `json:"reset"`
+	Events []Event `json:"events"`
+}
+
+// A Node is a page in the link graph. Exists is false for pages that
+// are linked to but do not exist.
+type Node struct {
+	ID       string   `json:"id"`
+	Exists   bool     `json:"exists"`
+	Tags     []string `json:"tags"`
+	LinksIn  int      `json:"links_in"`
+	LinksOut int      `json:"links_out"`
+}
+
+// An Edge is a link between pages, with Weight the number of times
+// Source links to Target.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+}
+
+// A Graph is the wiki's link graph.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// A TrendPoint is the number of views of a page on one day.
+type TrendPoint struct {
+	Day   string `json:"day"`
+	Views int    `json:"views"`
+}
+
+// Views is the view trend of a page.
+type Views struct {
+	Title string       `json:"title"`
+	Total int          `json:"total"`
+	Days  []TrendPoint `json:"days"`
+}
+
+// An Error is an unsuccessful response from the wiki.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// GetPage returns the page title.
+func (c *Client) GetPage(title string) (*Page, error) {
+	var p Page
+	h, err := c.doHeader("GET", "pages/"+url.PathEscape(title), "", nil, &p)
+	p.ETag = h.Get("ETag")
+	return &p, err
+}
+
+// PutPage creates or replaces the page title.
+func (c *Client) PutPage(title, body string) error {
+	return c.PutPageIfMatch(title, body, "")
+}
+
+// PutPageIfMatch replaces the page title only if it has not changed
+// since it was read with the given ETag. Otherwise it returns an *Error
+// with StatusCode 412.
+func (c *Client) PutPageIfMatch(title, body, etag string) error {
+	_, err := c.doHeader("PUT", "pages/"+url.PathEscape(title), etag, struct {
+		Body string `json:"body"`
+	}{body}, nil)
+	return err
+}
+
+// PatchPage applies edits to the page title. If etag is not empty, the
+// page must not have changed since it was read with it.
+func (c *Client) PatchPage(title string, edits []Edit, etag string) error {
+	_, err := c.doHeader("PATCH", "pages/"+url.PathEscape(title), etag, struct {
+		Edits []Edit `json:"edits"`
+	}{edits}, nil)
+	return err
+}
+
+// PatchPageDiff applies a unified diff to the page title. It returns an
+// *Error with StatusCode 409 if the diff does not apply.
+func (c *Client) PatchPageDiff(title, diff, etag string) error {
+	_, err := c.doHeader("PATCH", "pages/"+url.PathEscape(title), etag, rawBody{"text/x-diff", diff}, nil)
+	return err
+}
+
+// DeletePage deletes the page title, recording reason in its tombstone.
+func (c *Client) DeletePage(title, reason string) error {
+	return c.DeletePageIfMatch(title, reason, "")
+}
+
+// DeletePageIfMatch deletes the page title only if it has not changed
+// since it was read with the given ETag.
+func (c *Client) DeletePageIfMatch(title, reason, etag string) error {
+	q := url.Values{"reason": {reason}}
+	_, err := c.doHeader("DELETE", "pages/"+url.PathEscape(title)+"?"+q.Encode(), etag, nil, nil)
+	return err
+}
+
+// RestorePage brings back the page title as it was when last deleted.
+func (c *Client) RestorePage(title string) error {
+	return c.do("POST", "pages/"+url.PathEscape(title)+"/restore", nil, nil)
+}
+
+// MovePage renames the page from to to, rewriting links to it, and
+// returns the titles of the pages whose links changed.
+func (c *Client) MovePage(from, to string) ([]string, error) {
+	var res struct {
+		Updated []string `json:"updated"`
+	}
+	err := c.do("POST", "pages/"+url.PathEscape(from)+"/move", struct {
+		To string `json:"to"`
+	}{to}, &res)
+	return res.Updated, err
+}
+
+// DuplicatePage copies the page from to the new page to. A copy of a
+// protected page is not protected.
+func (c *Client) DuplicatePage(from, to string) error {
+	return c.do("POST", "pages/"+url.PathEscape(from)+"/duplicate", struct {
+		To string `json:"to"`
+	}{to}, nil)
+}
+
+// Batch applies ops in order and returns the result of each. An error
+// is only returned if the batch as a whole failed.
+func (c *Client) Batch(ops []Op) ([]Result, error) {
+	var res struct {
+		Results []Result `json:"results"`
+	}
+	err := c.do("POST", "batch", struct {
+		Ops []Op `json:"ops"`
+	}{ops}, &res)
+	return res.Results, err
+}
+
+// Watch waits up to timeout, at most two minutes, for changes after
+// cursor and returns them. An empty cursor returns the current one at
+// once.
+func (c *Client) Watch(cursor string, timeout time.Duration) (*Changes, error) {
+	var ch Changes
+	q := url.Values{"since": {cursor}, "timeout": {strconv.Itoa(int(timeout / time.Second))}}
+	return &ch, c.do("GET", "watch?"+q.Encode(), nil, &ch)
+}
+
+// Graph returns the link graph, limited to the pages tagged tag if it is
+// not empty. Pages that do not exist are included if missing is true.
+func (c *Client) Graph(tag string, missing bool) (*Graph, error) {
+	var g Graph
+	q := url.Values{"missing": {strconv.FormatBool(missing)}}
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+	return &g, c.do("GET", "graph?"+q.Encode(), nil, &g)
+}
+
+// Views returns the daily views of the page title.
+func (c *Client) Views(title string) (*Views, error) {
+	var v Views
+	return &v, c.do("GET", "views/"+url.PathEscape(title), nil, &v)
+}
+
+// Related returns the pages related to title.
+func (c *Client) Related(title string) ([]string, error) {
+	var res struct {
+		Related []string `json:"related"`
+	}
+	err := c.do("GET", "related/"+url.PathEscape(title), nil, &res)
+	return res.Related, err
+}
+
+// A rawBody is a request body sent as it is rather than as JSON.
+type rawBody struct {
+	contentType, body string
+}
+
+// do sends in, if not nil, as JSON, or as it is if it is a rawBody, to path under the API and decodes the
+// response into out, if not nil.
+func (c *Client) do(method, path string, in, out interface{}) error {
+	_, err := c.doHeader(method, path, "", in, out)
+	return err
+}
+
+// doHeader is do with an optional If-Match header. It also returns the
+// response header.
+func (c *Client) doHeader(method, path, ifMatch string, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	contentType := "application/json"
+	if raw, ok := in.(rawBody); ok {
+		body, contentType = strings.NewReader(raw.body), raw.contentType
+	} else if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.BaseURL, "/")+"/api/"+Version+"/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		// Page operations report their error in a Result.
+		var res Result
+		if json.Unmarshal(msg, &res) == nil && res.Error != "" {
+			e.Message = res.Error
+		}
+		return resp.Header, e
+	}
+	if out == nil {
+		return resp.Header, nil
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,107 @@
+// Command wikictl reads and writes gowiki pages from the command line.
+//
+//	wikictl get Title
+//	wikictl put Title -f file.txt
+//	echo "Hello" | wikictl put Title
+//	wikictl move Title NewTitle
+//	wikictl duplicate Title NewTitle
+//	wikictl delete Title reason...
+//	wikictl restore Title
+//
+// The server is http://localhost:8080 unless -server or $WIKI_SERVER says
+// otherwise. Commands that change pages need the server's API token,
+// given with -token or $WIKI_TOKEN.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/corburn/gowiki/client"
+)
+
+var (
+	server = flag.String("server", defaultServer(), "`URL` of the wiki")
+	token  = flag.String("token", os.Getenv("WIKI_TOKEN"), "API `token` of the wiki")
+)
+
+func defaultServer() string {
+	if s := os.Getenv("WIKI_SERVER"); s != "" {
+		return s
+	}
+	return "http://localhost:8080"
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: wikictl [-server URL] [-token token] get Title\n")
+	fmt.Fprintf(os.Stderr, "       wikictl [-server URL] [-token token] put Title [-f file]\n")
+	fmt.Fprintf(os.Stderr, "       wikictl [-server URL] [-token token] move Title NewTitle\n")
+	fmt.Fprintf(os.Stderr, "       wikictl [-server URL] [-token token] duplicate Title NewTitle\n")
+	fmt.Fprintf(os.Stderr, "       wikictl [-server URL] [-token token] delete Title reason...\n")
+	fmt.Fprintf(os.Stderr, "       wikictl [-server URL] [-token token] restore Title\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+	}
+	c := client.New(*server, *token)
+	var err error
+	switch cmd, title := flag.Arg(0), flag.Arg(1); cmd {
+	case "get":
+		var p *client.Page
+		if p, err = c.GetPage(title); err == nil {
+			_, err = os.Stdout.WriteString(p.Body)
+		}
+	case "put":
+		fs := flag.NewFlagSet("put", flag.ExitOnError)
+		file := fs.String("f", "-", "read the page body from `file`")
+		fs.Parse(flag.Args()[2:])
+		var body []byte
+		if body, err = readFile(*file); err == nil {
+			err = c.PutPage(title, string(body))
+		}
+	case "move":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		var updated []string
+		if updated, err = c.MovePage(title, flag.Arg(2)); err == nil && len(updated) > 0 {
+			fmt.Println("updated links in", strings.Join(updated, ", "))
+		}
+	case "duplicate":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		err = c.DuplicatePage(title, flag.Arg(2))
+	case "delete":
+		if flag.NArg() < 3 {
+			usage()
+		}
+		err = c.DeletePage(title, strings.Join(flag.Args()[2:], " "))
+	case "restore":
+		err = c.RestorePage(title)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wikictl:", err)
+		os.Exit(1)
+	}
+}
+
+// readFile returns the contents of file, or of standard input if file
+// is "-".
+func readFile(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var keyFile = flag.String("key-file", "", "`file` holding a hex encoded 32 byte key to encrypt pages at rest (or set $GOWIKI_KEY)")
+
+// pageCipher encrypts page bodies at rest. It is nil when no key is
+// configured.
+var pageCipher cipher.AEAD
+
+// sealedPrefix marks an encrypted body. Files without it are read as
+// plain text, so an existing wiki can be encrypted one save at a time.
+var sealedPrefix = []byte("gowiki:aes-256-gcm:")
+
+var errNoKey = errors.New("page is encrypted but no key is configured")
+
+// setupEncryption reads the key from -key-file or $GOWIKI_KEY.
+func setupEncryption() error {
+	hexKey := os.Getenv("GOWIKI_KEY")
+	if *keyFile != "" {
+		b, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		hexKey = string(b)
+	}
+	if hexKey == "" {
+		return nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(hexKey))
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("encryption key must be 32 bytes written as 64 hex digits")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	pageCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// sealBody encrypts b if a key is configured.
+func sealBody(b []byte) ([]byte, error) {
+	if pageCipher == nil {
+		return b, nil
+	}
+	nonce := make([]byte, pageCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, sealedPrefix...), nonce...)
+	return pageCipher.Seal(out, nonce, b, nil), nil
+}
+
+// openBody decrypts b if it was sealed, and returns it unchanged if not.
+func openBody(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, sealedPrefix) {
+		return b, nil
+	}
+	if pageCipher == nil {
+		return nil, errNoKey
+	}
+	b = b[len(sealedPrefix):]
+	if len(b) < pageCipher.NonceSize() {
+		return nil, errors.New("encrypted page is truncated")
+	}
+	return pageCipher.Open(nil, b[:pageCipher.NonceSize()], b[pageCipher.NonceSize():], nil)
+}
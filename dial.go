@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate
+// leaves out.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is an address on the public internet,
+// not a loopback, private, link-local or unspecified one.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// publicOnly is a net.Dialer Control function that refuses connections to
+// addresses that are not public. It runs after the name is resolved, so
+// a host name pointing at an internal address is refused too.
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// publicClient returns an HTTP client that only connects to public
+// addresses, for URLs that come from other servers or from pages, so
+// they cannot be used to reach services on the wiki's own network. It
+// ignores proxy settings, which would hide the address connected to.
+func publicClient(timeout time.Duration) *http.Client {
+	d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnly}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// diffContext is how many unchanged lines are shown around changes.
+	diffContext = 3
+	// diffMaxCells bounds the work of comparing two bodies line by line;
+	// beyond it the bodies are shown as wholly replaced.
+	diffMaxCells = 4 << 20
+)
+
+// A diffLine is one line of a diff: Op is ' ' for a line in both bodies,
+// '-' for one only in the old body and '+' for one only in the new.
+// Spans, when set, split a changed line into the words it shares with the
+// line it replaced or was replaced by, and the words that changed.
+type diffLine struct {
+	Op    byte
+	Text  string
+	Spans []diffSpan
+}
+
+// A diffSpan is part of a changed line.
+type diffSpan struct {
+	Text    string
+	Changed bool
+}
+
+// diffWord matches the units lines are compared in by markWords: words,
+// runs of space, and single other characters.
+var diffWord = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|.`)
+
+// markWords sets the Spans of lines that were changed rather than added
+// or removed: each run of removed lines followed by a run of added lines
+// is paired line by line, and the words of each pair are compared.
+// Pairs with no word in common are left whole.
+func markWords(lines []diffLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != '-' {
+			i++
+			continue
+		}
+		del := i
+		for i < len(lines) && lines[i].Op == '-' {
+			i++
+		}
+		ins := i
+		for i < len(lines) && lines[i].Op == '+' {
+			i++
+		}
+		for k := 0; del+k < ins && ins+k < i; k++ {
+			before, after := &lines[del+k], &lines[ins+k]
+			words := diffBodies(diffWord.FindAllString(before.Text, -1), diffWord.FindAllString(after.Text, -1))
+			shared := false
+			for _, w := range words {
+				if w.Op == ' ' && strings.TrimSpace(w.Text) != "" {
+					shared = true
+					break
+				}
+			}
+			if !shared {
+				continue
+			}
+			before.Spans, after.Spans = nil, nil
+			for _, w := range words {
+				if w.Op != '+' {
+					before.Spans = appendSpan(before.Spans, w.Text, w.Op == '-')
+				}
+				if w.Op != '-' {
+					after.Spans = appendSpan(after.Spans, w.Text, w.Op == '+')
+				}
+			}
+		}
+	}
+}
+
+// appendSpan adds text to spans, joining it to the last span if that is
+// changed or unchanged alike.
+func appendSpan(spans []diffSpan, text string, changed bool) []diffSpan {
+	if n := len(spans); n > 0 && spans[n-1].Changed == changed {
+		spans[n-1].Text += text
+		return spans
+	}
+	return append(spans, diffSpan{text, changed})
+}
+
+// diffBodies compares the lines of a and b by their longest common
+// subsequence.
+func diffBodies(a, b []string) []diffLine {
+	// Common prefixes and suffixes need no table.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var out []diffLine
+	for _, l := range a[:pre] {
+		out = append(out, diffLine{Op: ' ', Text: l})
+	}
+	out = append(out, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		out = append(out, diffLine{Op: ' ', Text: l})
+	}
+	return out
+}
+
+func diffMiddle(a, b []string) []diffLine {
+	var out []diffLine
+	if (len(a)+1)*(len(b)+1) > diffMaxCells {
+		for _, l := range a {
+			out = append(out, diffLine{Op: '-', Text: l})
+		}
+		for _, l := range b {
+			out = append(out, diffLine{Op: '+', Text: l})
+		}
+		return out
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, diffLine{Op: ' ', Text: a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{Op: '-', Text: a[i]})
+			i++
+		default:
+			out = append(out, diffLine{Op: '+', Text: b[j]})
+			j++
+		}
+	}
+	return out
+}
+
+// A diffHunk is a run of changes with the unchanged lines around them.
+type diffHunk struct {
+	OldStart, OldLen int
+	NewStart, NewLen int
+	Lines            []diffLine
+}
+
+// diffHunks groups lines into hunks with diffContext lines of context.
+func diffHunks(lines []diffLine) []diffHunk {
+	var hunks []diffHunk
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(lines); {
+		if lines[i].Op == ' ' {
+			i++
+			oldLine++
+			newLine++
+			continue
+		}
+		// Back up over the context before the change.
+		start := i
+		for start > 0 && i-start < diffContext && lines[start-1].Op == ' ' {
+			start--
+		}
+		h := diffHunk{OldStart: oldLine - (i - start), NewStart: newLine - (i - start)}
+		// Take changes until diffContext*2 unchanged lines separate them
+		// from the next.
+		end, same := i, 0
+		for ; end < len(lines) && same <= 2*diffContext; end++ {
+			if lines[end].Op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+		}
+		if same > diffContext {
+			end -= same - diffContext
+		}
+		h.Lines = lines[start:end]
+		for _, l := range h.Lines {
+			if l.Op != '+' {
+				h.OldLen++
+			}
+			if l.Op != '-' {
+				h.NewLen++
+			}
+		}
+		for _, l := range lines[i:end] {
+			if l.Op != '+' {
+				oldLine++
+			}
+			if l.Op != '-' {
+				newLine++
+			}
+		}
+		hunks = append(hunks, h)
+		i = end
+	}
+	return hunks
+}
+
+// unifiedDiff formats hunks as a unified diff from the page from to the
+// page to, which applyDiff can apply.
+func unifiedDiff(from, to string, hunks []diffHunk) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+	for _, h := range hunks {
+		oldStart, newStart := h.OldStart, h.NewStart
+		// Empty sides give the line before them, as diff -u does.
+		if h.OldLen == 0 {
+			oldStart--
+		}
+		if h.NewLen == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, h.OldLen, newStart, h.NewLen)
+		for _, l := range h.Lines {
+			b.WriteByte(l.Op)
+			b.WriteString(l.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// comparePages loads the pages named by the from and to form values and
+// compares their bodies. It writes an error and returns false when either
+// is missing.
+func comparePages(w http.ResponseWriter, r *http.Request) (from, to string, hunks []diffHunk, ok bool) {
+	from, to = r.FormValue("from"), r.FormValue("to")
+	var bodies [2][]string
+	for i, title := range []string{from, to} {
+		if !titleValidator.MatchString(title) {
+			http.Error(w, tr(r, "bad title %q", title), http.StatusBadRequest)
+			return
+		}
+		p, err := loadPage(title)
+		if err != nil {
+			http.Error(w, tr(r, "%s does not exist", title), http.StatusNotFound)
+			return
+		}
+		bodies[i] = splitLines(string(p.Body))
+	}
+	return from, to, diffHunks(diffBodies(bodies[0], bodies[1])), true
+}
+
+// Handler for /diff?from=<title>&to=<title>, which shows how the current
+// bodies of two pages differ, for merging duplicated pages. Within
+// changed lines, the words that changed are marked.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, hunks, ok := comparePages(w, r)
+	if !ok {
+		return
+	}
+	for _, h := range hunks {
+		markWords(h.Lines)
+	}
+	renderTemplate(w, r, "diff", struct {
+		From, To string
+		Hunks    []diffHunk
+		Changed  time.Time
+	}{from, to, hunks, time.Time{}})
+}
+
+// Handler for GET /api/v1/diff?from=<title>&to=<title>. It returns the
+// unified diff that turns the body of from into the body of to, which can
+// be sent back as a PATCH.
+func diffAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from, to, hunks, ok := comparePages(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	fmt.Fprint(w, unifiedDiff(from, to, hunks))
+}
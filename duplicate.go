@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// duplicateTarget checks that the page title can be copied to to, and
+// returns the status and the error if it cannot.
+func duplicateTarget(title, to string) (int, error) {
+	if !titleValidator.MatchString(to) {
+		return http.StatusBadRequest, newUserError("bad title %q", to)
+	}
+	if _, err := os.Stat(pageFile(title)); err != nil {
+		return http.StatusNotFound, newUserError("%s does not exist", title)
+	}
+	if _, err := os.Stat(pageFile(to)); err == nil {
+		return http.StatusConflict, newUserError("%s already exists", to)
+	}
+	return 0, nil
+}
+
+// Handler for /duplicate/<title>?to=NewTitle, which opens the editor of
+// the new page with a copy of the page, to use an existing page as a
+// template. Nothing is saved until the copy is. The copy leaves out the
+// page's trusted HTML blocks, which only the API can add; the API's
+// duplicate action keeps them.
+func duplicateHandler(w http.ResponseWriter, r *http.Request, title string) {
+	to := r.FormValue("to")
+	if code, err := duplicateTarget(title, to); err != nil {
+		http.Error(w, errorText(r, err), code)
+		return
+	}
+	http.Redirect(w, r, "/edit/"+to+"?from="+url.QueryEscape(title), http.StatusFound)
+}
+
+// duplicateBody returns the body of a copy of the page title. A copy of
+// a protected page is not protected.
+func duplicateBody(title string) ([]byte, error) {
+	p, err := loadPage(title)
+	if err != nil {
+		return nil, err
+	}
+	return unprotect(p.Body), nil
+}
+
+// Handler for POST /api/v1/pages/<title>/duplicate. The request body is
+// a JSON object naming the title of the copy, {"to": "NewTitle"}.
+func duplicateAPIHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !apiAuthorized(w, r) {
+		return
+	}
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer lockPages(title, req.To)()
+	if code, err := duplicateTarget(title, req.To); err != nil {
+		http.Error(w, errorText(r, err), code)
+		return
+	}
+	body, err := duplicateBody(title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := savePage(&Page{Title: req.To, Body: body}); err != nil {
+		http.Error(w, err.Error(), saveStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{title, req.To})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var editTokenTTL = flag.Duration("edit-token-ttl", 2*time.Hour, "how long an edit form can be saved after it was opened; 0 turns edit tokens off")
+
+// editSessionCookie names the cookie edit tokens are bound to. It is
+// SameSite=Strict, so a form posted from another site arrives without it
+// and its token does not verify.
+const editSessionCookie = "edit-session"
+
+var errEditToken = errors.New("the edit form has expired, was already saved or came from another site; reload the editor and save again")
+
+// editTokens signs the tokens embedded in edit forms. A token names the
+// page, the time the form was opened and the browser's edit session, and
+// can be used once, so stale forms, replayed posts and posts from other
+// sites are rejected even though the wiki has no accounts. The key is
+// made at startup, so forms opened before a restart must be reloaded.
+var editTokens = newEditTokenSigner()
+
+type editTokenSigner struct {
+	key []byte
+
+	mu    sync.Mutex
+	spent map[string]time.Time // token -> when it was issued
+}
+
+func newEditTokenSigner() *editTokenSigner {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &editTokenSigner{key: key, spent: make(map[string]time.Time)}
+}
+
+func (s *editTokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// editSession returns the browser's edit session, starting one if it has
+// none.
+func editSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(editSessionCookie); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	session := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: editSessionCookie, Value: session, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	return session, nil
+}
+
+// issue returns a token for editing title in session. The nonce keeps
+// forms opened in the same second from sharing a token.
+func (s *editTokenSigner) issue(title, session string) string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	payload := strconv.FormatInt(time.Now().Unix(), 10) + "-" + base64.RawURLEncoding.EncodeToString(nonce)
+	return payload + "." + s.sign(title+"."+session+"."+payload)
+}
+
+// verify checks the edit token of a save of title. It does not spend
+// the token, so a save refused for its content can be corrected and
+// posted again; call spend once the save is done. Every save passes when
+// -edit-token-ttl is 0.
+func (s *editTokenSigner) verify(r *http.Request, title string) error {
+	if *editTokenTTL <= 0 {
+		return nil
+	}
+	token := r.FormValue("edit_token")
+	c, err := r.Cookie(editSessionCookie)
+	i := strings.Index(token, ".")
+	if err != nil || i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(s.sign(title+"."+c.Value+"."+token[:i]))) {
+		return errEditToken
+	}
+	issued, ok := tokenIssued(token)
+	if !ok || time.Since(issued) > *editTokenTTL {
+		return errEditToken
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.spent[token]; ok {
+		return errEditToken
+	}
+	return nil
+}
+
+// spend marks the edit token of r, which verify accepted, as used, and
+// forgets the spent tokens that have expired anyway.
+func (s *editTokenSigner) spend(r *http.Request) {
+	if *editTokenTTL <= 0 {
+		return
+	}
+	token := r.FormValue("edit_token")
+	issued, ok := tokenIssued(token)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, at := range s.spent {
+		if now.Sub(at) > *editTokenTTL {
+			delete(s.spent, t)
+		}
+	}
+	s.spent[token] = issued
+}
+
+// tokenIssued returns the time the edit token was issued.
+func tokenIssued(token string) (time.Time, bool) {
+	stamp := strings.SplitN(token, "-", 2)[0]
+	issued, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(issued, 0), true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editPost returns a save of title with body and token from the edit
+// session session.
+func editPost(title, body, token, session string) *http.Request {
+	form := url.Values{"body": {body}, "edit_token": {token}}
+	r := httptest.NewRequest("POST", "/save/"+title, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if session != "" {
+		r.AddCookie(&http.Cookie{Name: editSessionCookie, Value: session})
+	}
+	return r
+}
+
+func TestEditTokens(t *testing.T) {
+	s := newEditTokenSigner()
+	other := newEditTokenSigner()
+	token := s.issue("Page", "session")
+	old := strconv.FormatInt(time.Now().Add(-3*time.Hour).Unix(), 10) + "-bm9uY2U"
+	stale := old + "." + s.sign("Page.session."+old)
+	tests := []struct {
+		name                  string
+		title, token, session string
+		ok                    bool
+	}{
+		{"fresh", "Page", token, "session", true},
+		{"other page", "Other", token, "session", false},
+		{"other session", "Page", token, "other", false},
+		{"no session", "Page", token, "", false},
+		{"other key", "Page", other.issue("Page", "session"), "session", false},
+		{"expired", "Page", stale, "session", false},
+		{"forged", "Page", strings.Replace(token, ".", "0.", 1), "session", false},
+		{"no signature", "Page", strings.SplitN(token, ".", 2)[0], "session", false},
+		{"empty", "Page", "", "session", false},
+	}
+	for _, tt := range tests {
+		err := s.verify(editPost(tt.title, "text", tt.token, tt.session), tt.title)
+		if tt.ok && err != nil {
+			t.Errorf("%s: verify = %v, want nil", tt.name, err)
+		} else if !tt.ok && err != errEditToken {
+			t.Errorf("%s: verify = %v, want %v", tt.name, err, errEditToken)
+		}
+	}
+
+	// A token verifies until it is spent, and never after.
+	r := editPost("Page", "text", token, "session")
+	for i := 0; i < 2; i++ {
+		if err := s.verify(r, "Page"); err != nil {
+			t.Fatalf("verify before spend: %v", err)
+		}
+	}
+	s.spend(r)
+	if err := s.verify(r, "Page"); err != errEditToken {
+		t.Errorf("verify after spend = %v, want %v", err, errEditToken)
+	}
+}
+
+// TestSaveSpendsToken checks that a save refused for its content leaves
+// the token to be used again, and that a saved edit cannot be replayed.
+func TestSaveSpendsToken(t *testing.T) {
+	testWiki(t, map[string]string{"Page": "one\n"})
+	defer func(q int) { *quota = q }(*quota)
+	*quota = 100
+	token := editTokens.issue("Page", "session")
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		page   string
+	}{
+		{"over quota", strings.Repeat("x", 200), http.StatusInsufficientStorage, "one\n"},
+		{"saved", "two\n", http.StatusFound, "two\n"},
+		{"replayed", "three\n", http.StatusForbidden, "two\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		saveHandler(w, editPost("Page", tt.body, token, "session"), "Page")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, w.Code, strings.TrimSpace(w.Body.String()), tt.status)
+		}
+		if body := pageBody(t, "Page"); body != tt.page {
+			t.Errorf("%s: page is %q, want %q", tt.name, body, tt.page)
+		}
+	}
+}
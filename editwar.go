@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	editWarReverts  = flag.Int("edit-war-reverts", 3, "`number` of reverts of a page within -edit-war-window that start a cooldown (0 disables)")
+	editWarWindow   = flag.Duration("edit-war-window", time.Hour, "how far back reverts are counted")
+	editWarCooldown = flag.Duration("edit-war-cooldown", 30*time.Minute, "how long saves from the edit form are refused after an edit war")
+)
+
+// editWars watches for pages whose edits keep being reverted. A save that
+// brings back a body the page had earlier within -edit-war-window is a
+// revert; after -edit-war-reverts of them, saves from the edit form are
+// refused for -edit-war-cooldown and the notification channels are
+// alerted. Saves through the API, by the wiki's editors, still go
+// through. The history is kept in memory only.
+var editWars = &editWarWatch{pages: make(map[string]*pageSaves)}
+
+type editWarWatch struct {
+	mu    sync.Mutex
+	pages map[string]*pageSaves
+}
+
+// pageSaves is the recent history of one page.
+type pageSaves struct {
+	saves   []timedETag
+	reverts []time.Time
+	until   time.Time // when the cooldown ends
+}
+
+type timedETag struct {
+	etag string
+	at   time.Time
+}
+
+func init() {
+	onPageSaved(func(p *Page) { editWars.saved(p) })
+	onPageDeleted(func(title string) { editWars.forget(title) })
+	registerSpecial("EditWars", &specialPage{
+		Description: "Pages whose edits keep being reverted, and those whose edit form is cooling down.",
+		Data:        func(r *http.Request) (interface{}, error) { return editWars.report(), nil },
+	})
+}
+
+// prune drops what is older than the window.
+func (s *pageSaves) prune(now time.Time) {
+	i := 0
+	for i < len(s.saves) && now.Sub(s.saves[i].at) > *editWarWindow {
+		i++
+	}
+	s.saves = s.saves[i:]
+	i = 0
+	for i < len(s.reverts) && now.Sub(s.reverts[i]) > *editWarWindow {
+		i++
+	}
+	s.reverts = s.reverts[i:]
+}
+
+func (w *editWarWatch) saved(p *Page) {
+	if *editWarReverts <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	s := w.pages[p.Title]
+	if s == nil {
+		s = &pageSaves{}
+		w.pages[p.Title] = s
+	}
+	s.prune(now)
+	etag := pageETag(p.Body)
+	// Saving the body the page already has is not a revert; bringing
+	// back any earlier one is.
+	reverted := false
+	if n := len(s.saves); n > 0 && s.saves[n-1].etag != etag {
+		for _, prev := range s.saves[:n-1] {
+			if prev.etag == etag {
+				s.reverts = append(s.reverts, now)
+				reverted = true
+				break
+			}
+		}
+	}
+	s.saves = append(s.saves, timedETag{etag, now})
+	if reverted && len(s.reverts) >= *editWarReverts && now.After(s.until) {
+		s.until = now.Add(*editWarCooldown)
+		msg := fmt.Sprintf("%s was reverted %d times in %s; edits are paused until %s", p.Title, len(s.reverts), *editWarWindow, s.until.Format("15:04 MST"))
+		log.Print(msg)
+		alertChannels(p.Title, msg)
+	}
+}
+
+func (w *editWarWatch) forget(title string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pages, title)
+}
+
+// cooldown returns when saves of title from the edit form may go ahead
+// again, or the zero time if they may now.
+func (w *editWarWatch) cooldown(title string) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s := w.pages[title]; s != nil && time.Now().Before(s.until) {
+		return s.until
+	}
+	return time.Time{}
+}
+
+// checkEditWar writes a 429 and returns false if saves of title are
+// cooling down.
+func checkEditWar(w http.ResponseWriter, r *http.Request, title string) bool {
+	until := editWars.cooldown(title)
+	if until.IsZero() {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	http.Error(w, tr(r, "This page's edits keep being reverted, so editing it is paused until %s. Please discuss the change first.", until.In(viewerLocation(r)).Format("15:04 MST")), http.StatusTooManyRequests)
+	return false
+}
+
+func (w *editWarWatch) report() []specialItem {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	var items []specialItem
+	for title, s := range w.pages {
+		s.prune(now)
+		if len(s.reverts) == 0 && now.After(s.until) {
+			continue
+		}
+		note := fmt.Sprintf("%d reverts in the last %s", len(s.reverts), *editWarWindow)
+		if now.Before(s.until) {
+			note += "; paused until " + s.until.Format("15:04 MST")
+		}
+		item := pageItem(title, note)
+		if len(s.saves) > 0 {
+			item.Time = s.saves[len(s.saves)-1].at
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+	return items
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var embedProviders = flag.String("embed", "", "comma separated `providers` whose links are embedded when alone on a line: youtube, vimeo, gist")
+
+const (
+	// embedTTL is how long oEmbed answers are cached.
+	embedTTL = 24 * time.Hour
+	// embedRetry is how long to wait before asking again after a failure.
+	embedRetry = 10 * time.Minute
+	// embedForget is how long an answer is kept after no page shows it.
+	embedForget = time.Hour
+	// embedMaxEntries is the most links the oEmbed cache holds.
+	embedMaxEntries = 10000
+)
+
+// An embedProvider turns links to a site into embedded players. The
+// markup is the wiki's own, so nothing the provider sends is put in the
+// page; its oEmbed endpoint is only asked for the title and size.
+type embedProvider struct {
+	// link matches a link to the provider, capturing what markup needs.
+	link *regexp.Regexp
+	// oembed is the provider's oEmbed endpoint, or "" if it has none.
+	oembed string
+	markup func(id string, info *oembedInfo) string
+}
+
+// oembedInfo is the part of an oEmbed answer the wiki uses.
+type oembedInfo struct {
+	Title  string `json:"title"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+var knownEmbeds = map[string]*embedProvider{
+	"youtube": {
+		link:   regexp.MustCompile(`^https://(?:www\.youtube\.com/watch\?v=|youtu\.be/)([\w-]{11})\S*$`),
+		oembed: "https://www.youtube.com/oembed?format=json&url=",
+		markup: func(id string, info *oembedInfo) string {
+			return iframeEmbed("https://www.youtube-nocookie.com/embed/"+id, info)
+		},
+	},
+	"vimeo": {
+		link:   regexp.MustCompile(`^https://vimeo\.com/(\d+)/?$`),
+		oembed: "https://vimeo.com/api/oembed.json?url=",
+		markup: func(id string, info *oembedInfo) string {
+			return iframeEmbed("https://player.vimeo.com/video/"+id, info)
+		},
+	},
+	"gist": {
+		link: regexp.MustCompile(`^https://gist\.github\.com/([\w-]+/[0-9a-f]+)/?$`),
+		markup: func(id string, info *oembedInfo) string {
+			return fmt.Sprintf(`<script src="https://gist.github.com/%s.js"></script>`, id)
+		},
+	},
+}
+
+func iframeEmbed(src string, info *oembedInfo) string {
+	width, height, title := 560, 315, ""
+	if info != nil {
+		if info.Width > 0 && info.Height > 0 {
+			width, height = info.Width, info.Height
+		}
+		title = info.Title
+	}
+	return fmt.Sprintf(`<iframe class="embed" src="%s" width="%d" height="%d" title="%s" frameborder="0" allow="encrypted-media; picture-in-picture; fullscreen" allowfullscreen></iframe>`,
+		src, width, height, html.EscapeString(title))
+}
+
+// checkEmbedProviders returns an error if -embed names a provider the
+// wiki does not know.
+func checkEmbedProviders() error {
+	if *embedProviders == "" {
+		return nil
+	}
+	for _, name := range strings.Split(*embedProviders, ",") {
+		if knownEmbeds[strings.TrimSpace(name)] == nil {
+			return fmt.Errorf("-embed: unknown provider %q", name)
+		}
+	}
+	return nil
+}
+
+// embedLine matches a line holding only a link.
+var embedLine = regexp.MustCompile(`(?m)^https://\S+[ \t\r]*$`)
+
+// renderEmbeds replaces the lines of escaped, which has already been
+// HTML escaped, that hold only a link to an enabled provider with its
+// player.
+func renderEmbeds(escaped []byte) []byte {
+	if *embedProviders == "" {
+		return escaped
+	}
+	return embedLine.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		link := strings.TrimSpace(html.UnescapeString(string(m)))
+		p, id := findEmbed(link)
+		if p == nil {
+			return m
+		}
+		var info *oembedInfo
+		if p.oembed != "" {
+			info = oembeds.lookup(p.oembed, link)
+		}
+		return []byte(p.markup(id, info))
+	})
+}
+
+// findEmbed returns the enabled provider of link and the part of link its
+// markup needs.
+func findEmbed(link string) (*embedProvider, string) {
+	for _, name := range strings.Split(*embedProviders, ",") {
+		p := knownEmbeds[strings.TrimSpace(name)]
+		if p == nil {
+			continue
+		}
+		if m := p.link.FindStringSubmatch(link); m != nil {
+			return p, m[1]
+		}
+	}
+	return nil, ""
+}
+
+// oembeds caches oEmbed answers. Pages are rendered without waiting for
+// them: a link not yet in the cache is shown at the default size, and the
+// answer is fetched in the background for the next view.
+var oembeds = &oembedCache{entries: make(map[string]*oembedEntry)}
+
+var oembedClient = &http.Client{Timeout: 10 * time.Second}
+
+type oembedCache struct {
+	mu      sync.Mutex
+	entries map[string]*oembedEntry // link -> answer
+	swept   time.Time
+}
+
+type oembedEntry struct {
+	info     *oembedInfo // nil while fetching or after a failure
+	expires  time.Time
+	fetching bool
+	used     time.Time // when a page last showed it
+}
+
+func init() {
+	onPageSaved(func(p *Page) {
+		if *embedProviders == "" {
+			return
+		}
+		// Fetch the answers for new links before the page is viewed.
+		for _, m := range embedLine.FindAll(pageContent(p.Body), -1) {
+			link := strings.TrimSpace(string(m))
+			if prov, _ := findEmbed(link); prov != nil && prov.oembed != "" {
+				oembeds.lookup(prov.oembed, link)
+			}
+		}
+	})
+}
+
+// lookup returns the cached answer for link, or nil, and fetches it from
+// endpoint if it is missing or stale.
+func (c *oembedCache) lookup(endpoint, link string) *oembedInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	e := c.entries[link]
+	if e == nil {
+		if len(c.entries) >= embedMaxEntries && !c.evict() {
+			return nil // every entry is being fetched
+		}
+		e = &oembedEntry{}
+		c.entries[link] = e
+	}
+	e.used = now
+	if !e.fetching && now.After(e.expires) {
+		e.fetching = true
+		go c.fetch(endpoint, link)
+	}
+	return e.info
+}
+
+// sweep forgets the links no page has shown for embedForget, looking at
+// most once a minute.
+func (c *oembedCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < time.Minute {
+		return
+	}
+	c.swept = now
+	for link, e := range c.entries {
+		if !e.fetching && now.Sub(e.used) > embedForget {
+			delete(c.entries, link)
+		}
+	}
+}
+
+// evict forgets the link shown least recently to make room for another,
+// and reports whether there was one not being fetched.
+func (c *oembedCache) evict() bool {
+	var oldest string
+	for link, e := range c.entries {
+		if !e.fetching && (oldest == "" || e.used.Before(c.entries[oldest].used)) {
+			oldest = link
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(c.entries, oldest)
+	return true
+}
+
+func (c *oembedCache) fetch(endpoint, link string) {
+	info, err := fetchOEmbed(endpoint + url.QueryEscape(link))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[link]
+	e.fetching = false
+	if err != nil {
+		log.Printf("oembed %s: %v", link, err)
+		e.expires = time.Now().Add(embedRetry)
+		return
+	}
+	e.info, e.expires = info, time.Now().Add(embedTTL)
+}
+
+func fetchOEmbed(u string) (*oembedInfo, error) {
+	resp, err := oembedClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var info oembedInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
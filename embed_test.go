@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOEmbedCacheForgets(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	endpoint := srv.URL + "/oembed?url="
+	now := time.Now()
+	c := &oembedCache{entries: map[string]*oembedEntry{
+		"shown":    {expires: now.Add(time.Hour), used: now.Add(-time.Minute)},
+		"unshown":  {expires: now.Add(time.Hour), used: now.Add(-2 * time.Hour)},
+		"fetching": {fetching: true, used: now.Add(-2 * time.Hour)},
+	}}
+	c.lookup(endpoint, "shown")
+	tests := []struct {
+		link string
+		kept bool
+	}{
+		{"shown", true},
+		{"unshown", false},
+		{"fetching", true},
+	}
+	for _, tt := range tests {
+		if _, ok := c.entries[tt.link]; ok != tt.kept {
+			t.Errorf("%s: kept %v, want %v", tt.link, ok, tt.kept)
+		}
+	}
+
+	// A full cache forgets the link shown least recently for a new one.
+	c = &oembedCache{entries: make(map[string]*oembedEntry), swept: now}
+	for i := 0; i < embedMaxEntries; i++ {
+		c.entries[strconv.Itoa(i)] = &oembedEntry{expires: now.Add(time.Hour), used: now.Add(time.Duration(i-embedMaxEntries) * time.Second)}
+	}
+	c.lookup(endpoint, "new")
+	if len(c.entries) != embedMaxEntries {
+		t.Errorf("%d entries, want %d", len(c.entries), embedMaxEntries)
+	}
+	if _, ok := c.entries["0"]; ok {
+		t.Error("the link shown least recently was kept")
+	}
+	if _, ok := c.entries["new"]; !ok {
+		t.Error("the new link was not added")
+	}
+	for fetching := true; fetching; {
+		time.Sleep(10 * time.Millisecond)
+		c.mu.Lock()
+		fetching = c.entries["new"].fetching
+		c.mu.Unlock()
+	}
+}
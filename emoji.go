@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var emojiFile = flag.String("emoji", "", "`file` of extra emoji shortcodes and the emoji they expand to")
+
+// defaultEmoji are the shortcodes known without an -emoji file.
+var defaultEmoji = map[string]string{
+	"+1":               "👍",
+	"-1":               "👎",
+	"thumbsup":         "👍",
+	"thumbsdown":       "👎",
+	"smile":            "😄",
+	"grin":             "😁",
+	"joy":              "😂",
+	"wink":             "😉",
+	"slightly_smiling": "🙂",
+	"thinking":         "🤔",
+	"confused":         "😕",
+	"cry":              "😢",
+	"heart":            "❤️",
+	"tada":             "🎉",
+	"rocket":           "🚀",
+	"fire":             "🔥",
+	"star":             "⭐",
+	"sparkles":         "✨",
+	"eyes":             "👀",
+	"wave":             "👋",
+	"clap":             "👏",
+	"pray":             "🙏",
+	"muscle":           "💪",
+	"ok_hand":          "👌",
+	"100":              "💯",
+	"bulb":             "💡",
+	"memo":             "📝",
+	"calendar":         "📅",
+	"lock":             "🔒",
+	"key":              "🔑",
+	"bug":              "🐛",
+	"wrench":           "🔧",
+	"hammer":           "🔨",
+	"warning":          "⚠️",
+	"x":                "❌",
+	"white_check_mark": "✅",
+	"heavy_check_mark": "✔️",
+	"question":         "❓",
+	"exclamation":      "❗",
+	"construction":     "🚧",
+	"coffee":           "☕",
+	"pizza":            "🍕",
+	"beers":            "🍻",
+}
+
+// emojiCode matches shortcodes such as :tada:.
+var emojiCode = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// emoji holds the shortcodes of the -emoji file, which add to or replace
+// the defaults. The file is re-read when it changes and holds one
+// "shortcode emoji" pair per line:
+//
+//	shipit  🐿️
+//	party   🎉
+var emoji = &emojiMap{}
+
+type emojiMap struct {
+	mu      sync.Mutex
+	modTime time.Time
+	codes   map[string]string
+}
+
+func (m *emojiMap) reload(filename string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(m.modTime) {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	codes := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !emojiCode.MatchString(":"+fields[0]+":") {
+			return fmt.Errorf("%s:%d: want a shortcode and an emoji", filename, n)
+		}
+		codes[strings.Trim(fields[0], ":")] = fields[1]
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	m.codes, m.modTime = codes, fi.ModTime()
+	return nil
+}
+
+// lookup returns the emoji for a shortcode, or false if it is unknown.
+func (m *emojiMap) lookup(code string) (string, bool) {
+	if *emojiFile != "" {
+		m.mu.Lock()
+		if err := m.reload(*emojiFile); err != nil {
+			log.Print(err)
+		}
+		e, ok := m.codes[code]
+		m.mu.Unlock()
+		if ok {
+			return e, true
+		}
+	}
+	e, ok := defaultEmoji[code]
+	return e, ok
+}
+
+// renderEmoji replaces known shortcodes in escaped, which has already
+// been HTML escaped, with their emoji. Unknown ones, such as the minutes
+// of 10:30:00, are left alone.
+func renderEmoji(escaped []byte) []byte {
+	return emojiCode.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		if e, ok := emoji.lookup(string(m[1 : len(m)-1])); ok {
+			return []byte(e)
+		}
+		return m
+	})
+}
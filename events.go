@@ -0,0 +1,80 @@
+package main
+
+import "sync"
+
+// The wiki announces changes to pages on an in-process event bus, so the
+// indexes, notifications and feeds that react to them subscribe in their
+// own files instead of savePage calling each one. Handlers run in the
+// goroutine that made the change, in the order they subscribed, and must
+// not block; slow work belongs in a goroutine of its own.
+//
+// User registration and attachment uploads would be events too, but the
+// wiki has neither.
+var pageEvents = &eventBus{}
+
+type eventBus struct {
+	mu      sync.RWMutex
+	saved   []func(p *Page)
+	changed []func(p *Page, old []byte)
+	deleted []func(title string)
+	moved   []func(from, to string)
+}
+
+// onPageSaved subscribes fn to pages being created or changed.
+func onPageSaved(fn func(p *Page)) {
+	pageEvents.mu.Lock()
+	defer pageEvents.mu.Unlock()
+	pageEvents.saved = append(pageEvents.saved, fn)
+}
+
+// onPageChanged subscribes fn to pages being created or changed, like
+// onPageSaved, and gives it the body the page had before, nil for a new
+// page.
+func onPageChanged(fn func(p *Page, old []byte)) {
+	pageEvents.mu.Lock()
+	defer pageEvents.mu.Unlock()
+	pageEvents.changed = append(pageEvents.changed, fn)
+}
+
+// onPageDeleted subscribes fn to pages being deleted.
+func onPageDeleted(fn func(title string)) {
+	pageEvents.mu.Lock()
+	defer pageEvents.mu.Unlock()
+	pageEvents.deleted = append(pageEvents.deleted, fn)
+}
+
+// onPageMoved subscribes fn to pages being renamed. A move also saves the
+// page under its new title and deletes the old one, so subscribers to
+// those events need not subscribe to this one.
+func onPageMoved(fn func(from, to string)) {
+	pageEvents.mu.Lock()
+	defer pageEvents.mu.Unlock()
+	pageEvents.moved = append(pageEvents.moved, fn)
+}
+
+func (b *eventBus) pageSaved(p *Page, old []byte) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.saved {
+		fn(p)
+	}
+	for _, fn := range b.changed {
+		fn(p, old)
+	}
+}
+
+func (b *eventBus) pageDeleted(title string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.deleted {
+		fn(title)
+	}
+}
+
+func (b *eventBus) pageMoved(from, to string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.moved {
+		fn(from, to)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reviewKeys are the front matter keys that give the date a page should
+// be reviewed by. The first one present wins.
+var reviewKeys = []string{"review", "expires"}
+
+// reviewDate returns the review date in a Page's metadata.
+func reviewDate(meta map[string]string) (time.Time, bool) {
+	for _, key := range reviewKeys {
+		if v, ok := meta[key]; ok {
+			t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// expiry tracks the review dates of pages and which of them have passed.
+// Dates are read at startup and on save; a background job marks pages as
+// expired as their dates pass.
+var expiry = &expiryIndex{}
+
+type expiryIndex struct {
+	mu      sync.Mutex
+	dates   map[string]time.Time
+	expired map[string]bool
+}
+
+func init() {
+	onPageSaved(expiry.saved)
+	onPageDeleted(expiry.deleted)
+	registerSpecial("ExpiredContent", &specialPage{
+		Description: "Pages whose review date has passed.",
+		Data:        func(r *http.Request) (interface{}, error) { return expiry.report(), nil },
+	})
+}
+
+func (x *expiryIndex) scan() error {
+	titles, err := listPages()
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x.dates = make(map[string]time.Time)
+	x.expired = make(map[string]bool)
+	x.mu.Unlock()
+	for _, title := range titles {
+		p, err := loadPage(title)
+		if err != nil {
+			return err
+		}
+		x.saved(p)
+	}
+	x.check()
+	return nil
+}
+
+// saved records the review date of p.
+func (x *expiryIndex) saved(p *Page) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	date, ok := reviewDate(pageMeta(p.Body))
+	if !ok {
+		delete(x.dates, p.Title)
+		delete(x.expired, p.Title)
+		return
+	}
+	x.dates[p.Title] = date
+	x.expired[p.Title] = !time.Now().Before(date)
+}
+
+// deleted forgets the review date of the Page title.
+func (x *expiryIndex) deleted(title string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.dates, title)
+	delete(x.expired, title)
+}
+
+// check flags pages whose review date has passed.
+func (x *expiryIndex) check() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := time.Now()
+	for title, date := range x.dates {
+		if !now.Before(date) && !x.expired[title] {
+			x.expired[title] = true
+			log.Printf("%s is due for review since %s", title, date.Format("2006-01-02"))
+		}
+	}
+}
+
+// expiredSince is the expiredSince template function. It returns the
+// review date of title if it has passed, or nil.
+func expiredSince(title string) *time.Time {
+	expiry.mu.Lock()
+	defer expiry.mu.Unlock()
+	if !expiry.expired[title] {
+		return nil
+	}
+	date := expiry.dates[title]
+	return &date
+}
+
+func (x *expiryIndex) report() []specialItem {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var titles []string
+	for title, expired := range x.expired {
+		if expired {
+			titles = append(titles, title)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool { return x.dates[titles[i]].Before(x.dates[titles[j]]) })
+	items := make([]specialItem, len(titles))
+	for i, title := range titles {
+		items[i] = pageItem(title, "review was due "+x.dates[title].Format("2006-01-02"))
+	}
+	return items
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	fetchHosts = flag.String("fetch-hosts", "", "comma separated `hosts` the fetch macro may read from; it is off without them")
+	fetchEvery = flag.Duration("fetch-every", 5*time.Minute, "how often the fetch macro reads its URLs again")
+)
+
+// fetchMaxSize is the most the fetch macro reads of a response.
+const fetchMaxSize = 1 << 20
+
+// fetchForget is how long what was read from a URL is kept after no page
+// shows it any more, as when the page using it was edited or deleted.
+const fetchForget = time.Hour
+
+func init() {
+	registerMacro("fetch", fetchMacro)
+}
+
+var (
+	fetchTemplateName = regexp.MustCompile(`^[a-z0-9-]+$`)
+	errFetchOff       = errors.New("fetching is off; see -fetch-hosts")
+)
+
+// fetchMacro is the fetch macro, which shows remote content in a page,
+// for dashboards:
+//
+//	{{fetch "https://status.example.com/status.json" status}}
+//
+// reads the URL and renders it with the template fetch-status.html from
+// the theme or the working directory. The template is given .URL, .Data,
+// the decoded JSON or, for other content, the text, and .Fetched, the
+// time it was read. Only the -fetch-hosts may be read, and templates are
+// files, not pages, so editors can neither reach internal services nor
+// write unescaped HTML.
+//
+// Pages are rendered without waiting: content is read in the background
+// and kept for -fetch-every, and a URL not read yet shows as loading.
+func fetchMacro(args []string) (template.HTML, error) {
+	if len(args) != 2 {
+		return "", errors.New(`want {{fetch "URL" template}}`)
+	}
+	u, name := args[0], args[1]
+	if err := fetchAllowed(u); err != nil {
+		return "", err
+	}
+	if !fetchTemplateName.MatchString(name) {
+		return "", fmt.Errorf("bad template name %q", name)
+	}
+	t, err := fetchTemplates.get(name)
+	if err != nil {
+		return "", err
+	}
+	e := fetched.lookup(u)
+	if e == nil {
+		return template.HTML(`<span class="fetch-loading">` + template.HTMLEscapeString("Loading "+u+"…") + `</span>`), nil
+	}
+	if e.err != nil {
+		return "", e.err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, struct {
+		URL     string
+		Data    interface{}
+		Fetched time.Time
+	}{u, e.data, e.fetched}); err != nil {
+		return "", err
+	}
+	return template.HTML(b.String()), nil
+}
+
+// fetchAllowed returns an error unless u is an http or https URL on one
+// of the -fetch-hosts.
+func fetchAllowed(u string) error {
+	if *fetchHosts == "" {
+		return errFetchOff
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return fmt.Errorf("%s: not a web address", u)
+	}
+	for _, h := range strings.Split(*fetchHosts, ",") {
+		if strings.EqualFold(strings.TrimSpace(h), pu.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not one of -fetch-hosts", pu.Host)
+}
+
+// fetchTemplates caches the parsed fetch templates until their files
+// change.
+var fetchTemplates = &fetchTemplateCache{m: make(map[string]*fetchTemplate)}
+
+type fetchTemplateCache struct {
+	mu sync.Mutex
+	m  map[string]*fetchTemplate
+}
+
+type fetchTemplate struct {
+	mod time.Time
+	t   *template.Template
+}
+
+func (c *fetchTemplateCache) get(name string) (*template.Template, error) {
+	file := themeDir("fetch-" + name + ".html")
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) && *themeName != "" {
+		file = "fetch-" + name + ".html"
+		fi, err = os.Stat(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no template %s", name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ft := c.m[name]; ft != nil && ft.mod.Equal(fi.ModTime()) {
+		return ft.t, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	c.m[name] = &fetchTemplate{fi.ModTime(), t}
+	return t, nil
+}
+
+// fetched caches what the fetch macro read.
+var fetched = &fetchCache{entries: make(map[string]*fetchEntry)}
+
+// fetchClient does not follow redirects away from the -fetch-hosts.
+var fetchClient = &http.Client{
+	Timeout: 15 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return fetchAllowed(req.URL.String())
+	},
+}
+
+type fetchCache struct {
+	mu      sync.Mutex
+	entries map[string]*fetchEntry // URL -> content
+	swept   time.Time
+}
+
+type fetchEntry struct {
+	data     interface{}
+	err      error
+	fetched  time.Time
+	next     time.Time
+	fetching bool
+	read     bool
+	used     time.Time // when a page last showed it
+}
+
+// lookup returns what was last read from u, or nil if it has not been
+// read yet, and reads it again in the background when it is due.
+func (c *fetchCache) lookup(u string) *fetchEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	e := c.entries[u]
+	if e == nil {
+		e = &fetchEntry{}
+		c.entries[u] = e
+	}
+	e.used = now
+	if !e.fetching && now.After(e.next) {
+		e.fetching = true
+		go c.fetch(u)
+	}
+	if !e.read {
+		return nil
+	}
+	snapshot := *e
+	return &snapshot
+}
+
+// sweep forgets the URLs no page has shown for fetchForget, looking at
+// most once a minute. c.mu must be held.
+func (c *fetchCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < time.Minute {
+		return
+	}
+	c.swept = now
+	for u, e := range c.entries {
+		if !e.fetching && now.Sub(e.used) > fetchForget {
+			delete(c.entries, u)
+		}
+	}
+}
+
+func (c *fetchCache) fetch(u string) {
+	data, err := fetchContent(u)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[u]
+	e.fetching, e.read, e.next = false, true, time.Now().Add(*fetchEvery)
+	if err != nil {
+		log.Printf("fetch %s: %v", u, err)
+		// Keep showing what was read before, if anything.
+		if e.fetched.IsZero() {
+			e.err = err
+		}
+		return
+	}
+	e.data, e.err, e.fetched = data, nil, time.Now()
+}
+
+// fetchContent reads u and returns its decoded JSON, or its text if it is
+// not JSON.
+func fetchContent(u string) (interface{}, error) {
+	resp, err := fetchClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, fetchMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		var data interface{}
+		if err := json.Unmarshal(b, &data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return string(b), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// This file has the general purpose template functions, so themes can
+// format values themselves instead of relying on handlers to do it.
+
+// formatDate formats t with layout, a Go time layout or one of "date",
+// "datetime" and "rfc3339". The date template function calls it with t
+// in the reader's time zone (see readerPrefs).
+func formatDate(layout string, t time.Time) string {
+	switch layout {
+	case "date":
+		layout = "2006-01-02"
+	case "datetime":
+		layout = "2006-01-02 15:04"
+	case "rfc3339":
+		layout = time.RFC3339
+	}
+	return t.Format(layout)
+}
+
+// ago is the ago template function of the language of c. It describes
+// t relative to now, as in "3 hours ago" or "in 2 days".
+func (c catalog) ago(t time.Time) string {
+	d := time.Since(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return c.translate("just now")
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return c.translate("in %d "+unit, n)
+	}
+	return c.translate("%d "+unit+" ago", n)
+}
+
+// truncate is the truncate template function. It shortens s to at most
+// n characters, breaking at a space where it can and marking the cut
+// with an ellipsis.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	cut := string([]rune(s)[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		return cut[:i] + "…"
+	}
+	return cut + "…"
+}
+
+// markup is the markup template function: render for a snippet of wiki
+// text held in a string.
+func markup(s string) template.HTML {
+	return renderBody([]byte(s))
+}
+
+// viewerLocation returns the time zone chosen on the preferences page,
+// or the server's.
+func viewerLocation(r *http.Request) *time.Location {
+	if tz := pref(r, "tz"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// wikiURL is the url template function. It joins its arguments into a
+// path under the path of -base-url, so links keep working when the wiki
+// is served below the root of a site: with -base-url
+// https://example.com/wiki, url "view" "FrontPage" is
+// /wiki/view/FrontPage.
+func wikiURL(elem ...string) string {
+	path := ""
+	if u, err := url.Parse(*baseURL); err == nil {
+		path = strings.TrimSuffix(u.Path, "/")
+	}
+	for _, e := range elem {
+		path += "/" + url.PathEscape(e)
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	gitRepo   = flag.String("git-repo", "", "`dir` of a git clone whose Markdown files are synced into the wiki on push")
+	gitDir    = flag.String("git-dir", "", "sync only files in this `subdirectory` of -git-repo")
+	gitBranch = flag.String("git-branch", "", "sync only pushes to this `branch`")
+	gitSecret = flag.String("git-secret", "", "webhook `secret` shared with GitHub or GitLab")
+)
+
+// gitSyncing serialises syncs, as pushes can arrive faster than a pull.
+var gitSyncing sync.Mutex
+
+// Handler for /hooks/git, the receiver for GitHub and GitLab push
+// webhooks. The clone in -git-repo is pulled and every .md or .txt file
+// named like a wiki page replaces that page if its content differs.
+func gitHookHandler(w http.ResponseWriter, r *http.Request) {
+	if *gitRepo == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validGitHook(r, payload) {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	json.Unmarshal(payload, &push)
+	if *gitBranch != "" && push.Ref != "refs/heads/"+*gitBranch {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	go func() {
+		if err := gitSync(); err != nil {
+			log.Printf("git sync: %v", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validGitHook checks a GitHub HMAC signature or a GitLab token against
+// -git-secret.
+func validGitHook(r *http.Request, payload []byte) bool {
+	if *gitSecret == "" {
+		return false
+	}
+	if sig := r.Header.Get("X-Hub-Signature-256"); sig != "" {
+		mac := hmac.New(sha256.New, []byte(*gitSecret))
+		mac.Write(payload)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(sig), []byte(want))
+	}
+	token := r.Header.Get("X-Gitlab-Token")
+	return token != "" && hmac.Equal([]byte(token), []byte(*gitSecret))
+}
+
+// gitSync pulls the repository and updates the pages that changed.
+func gitSync() error {
+	gitSyncing.Lock()
+	defer gitSyncing.Unlock()
+	if out, err := exec.Command("git", "-C", *gitRepo, "pull", "--ff-only").CombinedOutput(); err != nil {
+		return fmt.Errorf("git pull: %v: %s", err, bytes.TrimSpace(out))
+	}
+	files, err := ioutil.ReadDir(filepath.Join(*gitRepo, *gitDir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		ext := filepath.Ext(fi.Name())
+		title := strings.TrimSuffix(fi.Name(), ext)
+		if fi.IsDir() || ext != ".md" && ext != ".txt" || !titleValidator.MatchString(title) {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(*gitRepo, *gitDir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		if err := gitSyncPage(title, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitSyncPage saves the Page title with body from the repository, unless
+// it already has that body.
+func gitSyncPage(title string, body []byte) error {
+	defer lockPages(title)()
+	if p, err := loadPage(title); err == nil && bytes.Equal(p.Body, body) {
+		return nil
+	}
+	if err := savePage(&Page{Title: title, Body: body}); err != nil {
+		return err
+	}
+	log.Printf("git sync: updated %s", title)
+	return nil
+}
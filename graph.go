@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// A graphNode is a page in the link graph. Pages that are linked to but
+// do not exist are nodes too, with Exists false.
+type graphNode struct {
+	ID       string   `json:"id"`
+	Exists   bool     `json:"exists"`
+	Tags     []string `json:"tags"`
+	LinksIn  int      `json:"links_in"`
+	LinksOut int      `json:"links_out"`
+}
+
+// A graphEdge is a link from Source to Target, with Weight the number of
+// times Source links to Target.
+type graphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+}
+
+// export returns the nodes and edges of the graph, sorted. If tag is not
+// empty, only pages with that tag are included. If missing is false,
+// pages that do not exist are left out.
+func (g *graph) export(tag string, missing bool) ([]graphNode, []graphEdge) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	include := func(title string) bool {
+		if _, exists := g.links[title]; !exists {
+			return missing && tag == ""
+		}
+		if tag == "" {
+			return true
+		}
+		for _, t := range g.tags[title] {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+	nodes := make(map[string]*graphNode)
+	node := func(title string) *graphNode {
+		n := nodes[title]
+		if n == nil {
+			_, exists := g.links[title]
+			n = &graphNode{ID: title, Exists: exists, Tags: g.tags[title]}
+			if n.Tags == nil {
+				n.Tags = []string{}
+			}
+			nodes[title] = n
+		}
+		return n
+	}
+	edges := []graphEdge{}
+	for from, links := range g.links {
+		if !include(from) {
+			continue
+		}
+		node(from)
+		for _, to := range links {
+			if to == from || !include(to) {
+				continue
+			}
+			node(from).LinksOut++
+			node(to).LinksIn++
+			edges = append(edges, graphEdge{from, to, g.counts[from][to]})
+		}
+	}
+	list := make([]graphNode, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, *n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Source != edges[j].Source {
+			return edges[i].Source < edges[j].Source
+		}
+		return edges[i].Target < edges[j].Target
+	})
+	return list, edges
+}
+
+// allTags returns the sorted tags used by any page.
+func (g *graph) allTags() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seen := make(map[string]bool)
+	var tags []string
+	for _, pt := range g.tags {
+		for _, tag := range pt {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Handler for /graph, which draws the link graph from /api/v1/graph,
+// limited to the pages with the tag given as ?tag=.
+func graphHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "graph", struct {
+		Tag  string
+		Tags []string
+	}{r.FormValue("tag"), linkGraph.allTags()})
+}
+
+// Handler for GET /api/v1/graph?tag=<tag>&missing=false. It returns the
+// link graph for drawing: {"nodes": [...], "edges": [...]}. With tag, the
+// graph is limited to the pages with that tag and the links between
+// them; missing=false leaves out pages that do not exist.
+func graphAPIHandler(w http.ResponseWriter, r *http.Request) {
+	nodes, edges := linkGraph.export(r.FormValue("tag"), r.FormValue("missing") != "false")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Nodes []graphNode `json:"nodes"`
+		Edges []graphEdge `json:"edges"`
+	}{nodes, edges})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localesDir holds a message catalog per language, such as
+// locales/de.json. A catalog is a JSON object mapping the English text
+// of a message to its translation. Messages may contain fmt verbs,
+// which the translation must keep in the same order.
+const localesDir = "locales"
+
+// A catalog holds the translations of one language.
+type catalog map[string]string
+
+// catalogs maps language tags to their catalogs. English, the language
+// the messages are written in, needs no catalog.
+var catalogs = map[string]catalog{}
+
+// loadCatalogs reads every catalog in localesDir.
+func loadCatalogs() error {
+	files, err := filepath.Glob(filepath.Join(localesDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		var c catalog
+		if err := json.Unmarshal(b, &c); err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(filepath.Base(f), ".json"))] = c
+	}
+	return nil
+}
+
+// translate is the t template function of the language of c. Messages
+// missing from c are left in English.
+func (c catalog) translate(msg string, args ...interface{}) string {
+	if s := c[msg]; s != "" {
+		msg = s
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// tr returns msg in the language of r, formatted with args, for the
+// messages handlers answer with.
+func tr(r *http.Request, msg string, args ...interface{}) string {
+	return catalogs[requestLang(r)].translate(msg, args...)
+}
+
+// A translatable error can give its message in the reader's language.
+type translatable interface {
+	message() (msg string, args []interface{})
+}
+
+// A userError is an error meant for the reader, with a message from the
+// catalogs.
+type userError struct {
+	msg  string
+	args []interface{}
+}
+
+// newUserError returns a userError with msg, formatted with args as by
+// fmt.Sprintf.
+func newUserError(msg string, args ...interface{}) error {
+	return &userError{msg, args}
+}
+
+func (e *userError) Error() string {
+	return catalog(nil).translate(e.msg, e.args...)
+}
+
+func (e *userError) message() (string, []interface{}) {
+	return e.msg, e.args
+}
+
+// errorText returns the message of err in the language of r. Errors made
+// with errors.New are looked up by their text.
+func errorText(r *http.Request, err error) string {
+	if t, ok := err.(translatable); ok {
+		msg, args := t.message()
+		return tr(r, msg, args...)
+	}
+	return tr(r, err.Error())
+}
+
+// languages returns the tags of the available languages, English first.
+func languages() []string {
+	tags := []string{"en"}
+	for tag := range catalogs {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags[1:])
+	return tags
+}
+
+// requestLang returns the language to answer r in: the one chosen on
+// the preferences page if there is a catalog for it, or else the best
+// match for the Accept-Language header. "en" means untranslated.
+func requestLang(r *http.Request) string {
+	if lang := pref(r, "lang"); lang != "" {
+		if _, ok := catalogs[lang]; ok || lang == "en" {
+			return lang
+		}
+	}
+	best, bestQ := "en", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag = part[:i]
+			if v := strings.TrimSpace(part[i+1:]); strings.HasPrefix(v, "q=") {
+				q, _ = strconv.ParseFloat(v[2:], 64)
+			}
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if i := strings.Index(tag, "-"); i >= 0 {
+			tag = tag[:i]
+		}
+		if _, ok := catalogs[tag]; (ok || tag == "en") && q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var interwikiFile = flag.String("interwiki", "", "`file` of interwiki prefixes and the URLs they expand to")
+
+// defaultInterwiki is used when no -interwiki file is given.
+var defaultInterwiki = map[string]string{
+	"wikipedia": "https://en.wikipedia.org/wiki/$1",
+}
+
+// interwikiLink matches links into other sites of the form
+// [prefix:Target], for example [wikipedia:Go (programming language)].
+var interwikiLink = regexp.MustCompile(`\[([a-zA-Z][a-zA-Z0-9]*):([^\[\]\n]+)\]`)
+
+// interwiki maps prefixes to URL templates in which $1 is replaced by the
+// link target. The file is re-read when it changes and holds one
+// "prefix URL" pair per line:
+//
+//	wikipedia https://en.wikipedia.org/wiki/$1
+//	jira      https://jira.example.com/browse/$1
+var interwiki = &interwikiMap{}
+
+type interwikiMap struct {
+	mu      sync.Mutex
+	modTime time.Time
+	urls    map[string]string
+}
+
+func (m *interwikiMap) reload(filename string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(m.modTime) {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	urls := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want a prefix and a URL", filename, n)
+		}
+		urls[strings.ToLower(fields[0])] = fields[1]
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	m.urls, m.modTime = urls, fi.ModTime()
+	return nil
+}
+
+// lookup returns the URL for target under prefix, or false if the prefix
+// is unknown.
+func (m *interwikiMap) lookup(prefix, target string) (string, bool) {
+	urls := defaultInterwiki
+	if *interwikiFile != "" {
+		m.mu.Lock()
+		if err := m.reload(*interwikiFile); err != nil {
+			log.Print(err)
+		}
+		urls = m.urls
+		m.mu.Unlock()
+	}
+	pattern, ok := urls[strings.ToLower(prefix)]
+	if !ok {
+		return "", false
+	}
+	target = url.PathEscape(strings.TrimSpace(target))
+	if !strings.Contains(pattern, "$1") {
+		return pattern + target, true
+	}
+	return strings.Replace(pattern, "$1", target, -1), true
+}
+
+// renderInterwiki turns interwiki links in escaped, which has already been
+// HTML escaped, into links. Unknown prefixes are left alone.
+func renderInterwiki(escaped []byte) []byte {
+	return interwikiLink.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		sub := interwikiLink.FindSubmatch(m)
+		href, ok := interwiki.lookup(string(sub[1]), html.UnescapeString(string(sub[2])))
+		if !ok {
+			return m
+		}
+		if res, broken := linkCheck.broken(href); broken {
+			return []byte(fmt.Sprintf(`<a class="interwiki broken-link" href="%s" title="%s">%s:%s</a>`, html.EscapeString(href), html.EscapeString(brokenLinkTitle(res)), sub[1], sub[2]))
+		}
+		return []byte(fmt.Sprintf(`<a class="interwiki" href="%s">%s:%s</a>`, html.EscapeString(href), sub[1], sub[2]))
+	})
+}
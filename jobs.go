@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A job is work the wiki does in the background on a schedule. Each job
+// runs in its own goroutine and never overlaps itself: a run that is
+// still going when the next one is due makes that one be skipped.
+type job struct {
+	name   string
+	spec   string
+	sched  schedule
+	jitter time.Duration
+	run    func() error
+
+	lock sync.Mutex // held while the job runs
+
+	mu      sync.Mutex // guards the status below
+	next    time.Time
+	started time.Time
+	took    time.Duration
+	err     error
+	runs    int
+	skipped int
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*job)
+)
+
+func init() {
+	registerSpecial("Jobs", &specialPage{
+		Description: "Background jobs, when they last ran and when they run next.",
+		Data:        func(r *http.Request) (interface{}, error) { return jobReport(), nil },
+	})
+}
+
+// addJob schedules run by spec, delayed by a random time up to jitter so
+// that jobs due at the same time do not all start together. Jobs start
+// when startJobs is called.
+func addJob(name, spec string, jitter time.Duration, run func() error) error {
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", name, err)
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if _, dup := jobs[name]; dup {
+		panic("job " + name + " added twice")
+	}
+	jobs[name] = &job{name: name, spec: spec, sched: sched, jitter: jitter, run: run}
+	return nil
+}
+
+// startJobs starts every job added so far.
+func startJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		go j.loop()
+	}
+}
+
+func (j *job) loop() {
+	for {
+		next := j.sched.next(time.Now())
+		if j.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(j.jitter))))
+		}
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+		time.Sleep(time.Until(next))
+		go j.runOnce()
+	}
+}
+
+// runOnce runs the job unless it is already running.
+func (j *job) runOnce() {
+	if !j.lock.TryLock() {
+		j.mu.Lock()
+		j.skipped++
+		j.mu.Unlock()
+		log.Printf("job %s: skipped, the last run has not finished", j.name)
+		return
+	}
+	defer j.lock.Unlock()
+	start := time.Now()
+	err := j.run()
+	if err != nil {
+		log.Printf("job %s: %v", j.name, err)
+	}
+	j.mu.Lock()
+	j.started, j.took, j.err = start, time.Since(start), err
+	j.runs++
+	j.mu.Unlock()
+}
+
+func jobReport() []specialItem {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	var items []specialItem
+	for _, j := range jobs {
+		j.mu.Lock()
+		note := fmt.Sprintf("%s; next run %s", j.spec, j.next.Format("2006-01-02 15:04:05"))
+		switch {
+		case j.runs == 0:
+			note += "; not run yet"
+		case j.err != nil:
+			note += fmt.Sprintf("; last run failed after %s: %v", j.took.Round(time.Millisecond), j.err)
+		default:
+			note += fmt.Sprintf("; last run took %s", j.took.Round(time.Millisecond))
+		}
+		if j.skipped > 0 {
+			note += fmt.Sprintf("; %d runs skipped", j.skipped)
+		}
+		items = append(items, specialItem{Name: j.name, URL: "#" + j.name, Note: note, Time: j.started})
+		j.mu.Unlock()
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// A schedule says when a job is next due after a time.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// every is a schedule of a fixed interval.
+type every time.Duration
+
+func (d every) next(after time.Time) time.Time {
+	return after.Add(time.Duration(d))
+}
+
+// cronSchedule is a schedule in the five fields of crontab(5): minute,
+// hour, day of month, month and day of week. Each is a set of bits.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record which day fields are *. As in cron,
+	// when both are restricted a day matches if either one does.
+	domStar, dowStar bool
+}
+
+// parseSchedule parses a job schedule: "@every 30s", "@hourly",
+// "@daily", "@weekly" or five cron fields such as "30 3 * * 1-5".
+func parseSchedule(spec string) (schedule, error) {
+	switch spec = strings.TrimSpace(spec); {
+	case strings.HasPrefix(spec, "@every "):
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bad schedule %q", spec)
+		}
+		return every(d), nil
+	case spec == "@hourly":
+		spec = "0 * * * *"
+	case spec == "@daily":
+		spec = "0 0 * * *"
+	case spec == "@weekly":
+		spec = "0 0 * * 0"
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("bad schedule %q: want @every <duration> or five cron fields", spec)
+	}
+	var c cronSchedule
+	var err error
+	ranges := []struct {
+		bits     *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, r := range ranges {
+		if *r.bits, err = parseCronField(fields[i], r.min, r.max); err != nil {
+			return nil, fmt.Errorf("bad schedule %q: %v", spec, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is Sunday too
+	}
+	c.domStar, c.dowStar = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+// parseCronField parses a comma separated list of *, n, n-m, each
+// optionally followed by /step, into a set of bits.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Give up after five years, which only a schedule such as
+	// February 31 can reach.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return after.AddDate(100, 0, 0)
+}
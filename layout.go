@@ -0,0 +1,68 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"sync"
+	"time"
+)
+
+// includedKeep bounds how long a rendered included page is reused, so
+// that link check results and reloaded interwiki and emoji files show up
+// in it.
+const includedKeep = time.Minute
+
+// Pages such as Sidebar and Footer are shown on every page through the
+// includePage template function, used by the blocks in layout.html. They
+// are rendered once per revision, like preview cards, unless they use
+// macros, whose output changes with other pages and the time. The cache
+// is cleared whenever a page is saved or deleted, as links and macros
+// elsewhere may depend on it.
+var included = struct {
+	sync.Mutex
+	m map[string]includedPage
+}{m: make(map[string]includedPage)}
+
+type includedPage struct {
+	mod      time.Time
+	rendered time.Time
+	html     template.HTML
+}
+
+func init() {
+	onPageSaved(func(*Page) { clearIncluded() })
+	onPageDeleted(func(string) { clearIncluded() })
+}
+
+func clearIncluded() {
+	included.Lock()
+	included.m = make(map[string]includedPage)
+	included.Unlock()
+}
+
+// includePage is the includePage template function. It returns the
+// rendered body of the page title, or "" if there is no such page.
+func includePage(title string) template.HTML {
+	fi, err := os.Stat(pageFile(title))
+	if err != nil || !titleValidator.MatchString(title) {
+		return ""
+	}
+	included.Lock()
+	c, ok := included.m[title]
+	included.Unlock()
+	if ok && c.mod.Equal(fi.ModTime()) && time.Since(c.rendered) < includedKeep {
+		return c.html
+	}
+	p, err := loadPage(title)
+	if err != nil {
+		return ""
+	}
+	c = includedPage{mod: fi.ModTime(), rendered: time.Now(), html: renderBody(p.Body)}
+	if macroCall.Match(pageContent(p.Body)) {
+		return c.html
+	}
+	included.Lock()
+	included.m[title] = c
+	included.Unlock()
+	return c.html
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	linkCheckSchedule = flag.String("linkcheck", "", "`schedule` on which to check the external links of pages, such as @daily; empty disables the checker")
+	linkCheckDelay    = flag.Duration("linkcheck-delay", 2*time.Second, "least time between two link checks on the same host")
+)
+
+// externalURL matches the web addresses written in a page.
+var externalURL = regexp.MustCompile(`\bhttps?://[^\s<>"\[\]]+`)
+
+// pageURLs returns the distinct external URLs body links to: web
+// addresses written in it and the targets of its interwiki links.
+func pageURLs(body []byte) []string {
+	content := pageContent(body)
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(u string) {
+		if u = strings.TrimRight(u, ".,;:!?)'"); !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, u := range externalURL.FindAll(content, -1) {
+		add(string(u))
+	}
+	for _, m := range interwikiLink.FindAllSubmatch(content, -1) {
+		if u, ok := interwiki.lookup(string(m[1]), string(m[2])); ok {
+			add(u)
+		}
+	}
+	return urls
+}
+
+// linkCheck knows the external links of every page and, once the
+// checker has run, which of them are broken. Results are kept in memory
+// and checked again after a restart.
+var linkCheck = &linkChecker{}
+
+type linkChecker struct {
+	mu      sync.Mutex
+	urls    map[string][]string   // title -> external URLs
+	results map[string]linkResult // URL -> outcome of its last check
+}
+
+// A linkResult is the outcome of checking a URL.
+type linkResult struct {
+	Status  string // the HTTP status or the error
+	Broken  bool
+	Checked time.Time
+}
+
+// linkCheckClient fetches the links pages make. Anyone who can edit a
+// page chooses them, so it only connects to public addresses.
+var linkCheckClient = publicClient(15 * time.Second)
+
+func init() {
+	onPageSaved(linkCheck.saved)
+	onPageDeleted(linkCheck.deleted)
+	registerSpecial("BrokenLinks", &specialPage{
+		Description: "Pages linking to web addresses that could not be reached when last checked.",
+		Data:        func(r *http.Request) (interface{}, error) { return linkCheck.report(), nil },
+	})
+}
+
+func (c *linkChecker) scan() error {
+	titles, err := listPages()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.urls = make(map[string][]string, len(titles))
+	c.results = make(map[string]linkResult)
+	c.mu.Unlock()
+	for _, title := range titles {
+		p, err := loadPage(title)
+		if err != nil {
+			return err
+		}
+		c.saved(p)
+	}
+	return nil
+}
+
+// saved records the external links of p.
+func (c *linkChecker) saved(p *Page) {
+	urls := pageURLs(p.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls[p.Title] = urls
+}
+
+// deleted forgets the external links of the Page title.
+func (c *linkChecker) deleted(title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.urls, title)
+}
+
+// check requests every URL linked from a page once, in turn, leaving at
+// least -linkcheck-delay between requests to the same host. Servers
+// that do not allow HEAD are asked with GET.
+func (c *linkChecker) check() {
+	c.mu.Lock()
+	seen := make(map[string]bool)
+	var urls []string
+	for _, list := range c.urls {
+		for _, u := range list {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(urls)
+	last := make(map[string]time.Time) // host -> time of the last request
+	broken := 0
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		if wait := *linkCheckDelay - time.Since(last[parsed.Host]); wait > 0 {
+			time.Sleep(wait)
+		}
+		last[parsed.Host] = time.Now()
+		res := checkURL(u)
+		if res.Broken {
+			broken++
+		}
+		c.mu.Lock()
+		c.results[u] = res
+		c.mu.Unlock()
+	}
+	log.Printf("linkcheck: checked %d links, %d broken", len(urls), broken)
+}
+
+// checkURL requests u and reports whether it is broken.
+func checkURL(u string) linkResult {
+	res := linkResult{Checked: time.Now()}
+	var resp *http.Response
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			res.Status, res.Broken = err.Error(), true
+			return res
+		}
+		req.Header.Set("User-Agent", "gowiki-linkcheck")
+		if resp, err = linkCheckClient.Do(req); err != nil {
+			if ue, ok := err.(*url.Error); ok {
+				err = ue.Err // without the method and URL
+			}
+			res.Status, res.Broken = err.Error(), true
+			return res
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	res.Status, res.Broken = resp.Status, resp.StatusCode >= 400
+	return res
+}
+
+// broken returns the result for u if it was broken when last checked.
+func (c *linkChecker) broken(u string) (linkResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.results[u]
+	return res, ok && res.Broken
+}
+
+func (c *linkChecker) report() []specialItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var items []specialItem
+	for title, urls := range c.urls {
+		for _, u := range urls {
+			if res, ok := c.results[u]; ok && res.Broken {
+				item := pageItem(title, u+": "+res.Status)
+				item.Time = res.Checked
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Name != items[j].Name {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].Note < items[j].Note
+	})
+	return items
+}
+
+// brokenLinkTitle is the tooltip of a link flagged as broken.
+func brokenLinkTitle(res linkResult) string {
+	return fmt.Sprintf("broken link: %s on %s", res.Status, res.Checked.Format("2006-01-02"))
+}
+
+// renderBrokenURLs marks the web addresses in escaped, which has already
+// been HTML escaped, that were broken when last checked.
+func renderBrokenURLs(escaped []byte) []byte {
+	return externalURL.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		s := string(m)
+		u := strings.TrimRight(html.UnescapeString(s), ".,;:!?)'")
+		res, ok := linkCheck.broken(u)
+		if !ok {
+			return m
+		}
+		text := html.EscapeString(u)
+		return []byte(fmt.Sprintf(`<span class="broken-link" title="%s">%s</span>%s`, html.EscapeString(brokenLinkTitle(res)), text, strings.TrimPrefix(s, text)))
+	})
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pageLink matches inter-page links of the form [PageName].
+var pageLink = regexp.MustCompile(`\[([a-zA-Z0-9]+)\]`)
+
+// renderBody is the render template function. It escapes the Page body,
+// leaving out any front matter, and turns [PageName] into a link to that
+// Page and [prefix:Target] into an interwiki link. Emoji shortcodes such
+// as :tada: are expanded. Trusted HTML blocks are copied as they are,
+// macros are replaced by their output, and links to the -embed providers
+// on lines of their own become players.
+func renderBody(body []byte) template.HTML {
+	var out []byte
+	content := pageContent(body)
+	last := 0
+	for _, m := range trustedBlock.FindAllSubmatchIndex(content, -1) {
+		out = append(out, renderMacros(content[last:m[0]])...)
+		out = append(out, content[m[2]:m[3]]...)
+		last = m[1]
+	}
+	return template.HTML(append(out, renderMacros(content[last:])...))
+}
+
+// renderText renders text that is not trusted HTML.
+func renderText(text []byte) []byte {
+	escaped := renderInterwiki(renderBrokenURLs(renderEmoji([]byte(template.HTMLEscapeString(string(text))))))
+	return renderEmbeds(pageLink.ReplaceAllFunc(escaped, func(m []byte) []byte {
+		title := m[1 : len(m)-1]
+		return []byte(fmt.Sprintf(`<a href="/view/%s">%s</a>`, title, title))
+	}))
+}
+
+// pageLinks returns the distinct titles body links to.
+func pageLinks(body []byte) []string {
+	seen := make(map[string]bool)
+	var titles []string
+	for _, m := range pageLink.FindAllSubmatch(body, -1) {
+		if title := string(m[1]); !seen[title] {
+			seen[title] = true
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// rewriteLinks returns body with its links to the Page from changed into
+// links to the Page to.
+func rewriteLinks(body []byte, from, to string) []byte {
+	return pageLink.ReplaceAllFunc(body, func(m []byte) []byte {
+		if string(m[1:len(m)-1]) != from {
+			return m
+		}
+		return []byte("[" + to + "]")
+	})
+}
+
+// linkGraph records which pages link to which. Like stats it is built at
+// startup and updated as pages are saved.
+var linkGraph = &graph{}
+
+type graph struct {
+	mu     sync.Mutex
+	links  map[string][]string        // title -> titles it links to
+	back   map[string]map[string]bool // title -> titles linking to it
+	counts map[string]map[string]int  // title -> times it links to each title
+	tags   map[string][]string        // title -> its tags
+}
+
+func init() {
+	onPageSaved(linkGraph.saved)
+	onPageDeleted(linkGraph.deleted)
+	registerSpecial("WantedPages", &specialPage{
+		Description: "Pages that do not exist yet, by the number of pages linking to them.",
+		Data:        func(r *http.Request) (interface{}, error) { return linkGraph.wanted(), nil },
+	})
+	registerSpecial("Orphans", &specialPage{
+		Description: "Pages no other page links to.",
+		Data:        func(r *http.Request) (interface{}, error) { return linkGraph.orphans(), nil },
+	})
+}
+
+// scan reads the links of every page in the wiki.
+func (g *graph) scan() error {
+	titles, err := listPages()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.links = make(map[string][]string, len(titles))
+	g.back = make(map[string]map[string]bool)
+	g.counts = make(map[string]map[string]int, len(titles))
+	g.tags = make(map[string][]string, len(titles))
+	for _, title := range titles {
+		p, err := loadPage(title)
+		if err != nil {
+			return err
+		}
+		g.set(p)
+	}
+	return nil
+}
+
+func (g *graph) set(p *Page) {
+	for _, to := range g.links[p.Title] {
+		delete(g.back[to], p.Title)
+	}
+	links := pageLinks(p.Body)
+	for _, to := range links {
+		if to == p.Title {
+			continue
+		}
+		if g.back[to] == nil {
+			g.back[to] = make(map[string]bool)
+		}
+		g.back[to][p.Title] = true
+	}
+	g.links[p.Title] = links
+	counts := make(map[string]int, len(links))
+	for _, m := range pageLink.FindAllSubmatch(p.Body, -1) {
+		counts[string(m[1])]++
+	}
+	g.counts[p.Title] = counts
+	g.tags[p.Title] = pageTags(p.Body)
+}
+
+// saved updates the links of p.
+func (g *graph) saved(p *Page) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.set(p)
+}
+
+// deleted forgets the links of the Page title. Links to it remain, so
+// it becomes a wanted page if other pages still link to it.
+func (g *graph) deleted(title string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, to := range g.links[title] {
+		delete(g.back[to], title)
+	}
+	delete(g.links, title)
+	delete(g.counts, title)
+	delete(g.tags, title)
+}
+
+// backlinks returns the sorted titles of pages linking to title.
+func (g *graph) backlinks(title string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var titles []string
+	for from := range g.back[title] {
+		titles = append(titles, from)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func (g *graph) wanted() []specialItem {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var titles []string
+	for to, from := range g.back {
+		if _, exists := g.links[to]; !exists && len(from) > 0 {
+			titles = append(titles, to)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		a, b := len(g.back[titles[i]]), len(g.back[titles[j]])
+		if a != b {
+			return a > b
+		}
+		return titles[i] < titles[j]
+	})
+	items := make([]specialItem, len(titles))
+	for i, title := range titles {
+		n := len(g.back[title])
+		note := fmt.Sprintf("%d links", n)
+		if n == 1 {
+			note = "1 link"
+		}
+		items[i] = specialItem{Name: title, URL: "/edit/" + title, Note: note}
+	}
+	return items
+}
+
+func (g *graph) orphans() []specialItem {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var titles []string
+	for title := range g.links {
+		if len(g.back[title]) == 0 {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	items := make([]specialItem, len(titles))
+	for i, title := range titles {
+		items[i] = pageItem(title, "")
+	}
+	return items
+}
+
+// relatedLimit is the number of related pages shown on a Page.
+const relatedLimit = 5
+
+// neighbours returns the pages title links to or is linked from.
+// The caller must hold g.mu.
+func (g *graph) neighbours(title string) map[string]bool {
+	n := make(map[string]bool)
+	for _, to := range g.links[title] {
+		n[to] = true
+	}
+	for from := range g.back[title] {
+		n[from] = true
+	}
+	delete(n, title)
+	return n
+}
+
+// related returns up to limit existing pages close to title in the link
+// graph or sharing its tags. Direct neighbours score two, neighbours of
+// neighbours one and each tag shared with title one more, so pages
+// sharing many links and tags with title rank highest.
+func (g *graph) related(title string, limit int) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	score := make(map[string]int)
+	for n := range g.neighbours(title) {
+		score[n] += 2
+		for nn := range g.neighbours(n) {
+			score[nn]++
+		}
+	}
+	tags := make(map[string]bool)
+	for _, tag := range g.tags[title] {
+		tags[strings.ToLower(tag)] = true
+	}
+	if len(tags) > 0 {
+		for t, other := range g.tags {
+			for _, tag := range other {
+				if tags[strings.ToLower(tag)] {
+					score[t]++
+				}
+			}
+		}
+	}
+	var titles []string
+	for t := range score {
+		if _, exists := g.links[t]; exists && t != title {
+			titles = append(titles, t)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		if score[titles[i]] != score[titles[j]] {
+			return score[titles[i]] > score[titles[j]]
+		}
+		return titles[i] < titles[j]
+	})
+	if len(titles) > limit {
+		titles = titles[:limit]
+	}
+	return titles
+}
+
+// relatedPages is the related template function.
+func relatedPages(title string) []string {
+	return linkGraph.related(title, relatedLimit)
+}
+
+// Handler for /api/v1/related/<title>.
+func relatedAPIHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/api/v1/related/")
+	if !titleValidator.MatchString(title) {
+		http.NotFound(w, r)
+		return
+	}
+	related := relatedPages(title)
+	if related == nil {
+		related = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Title   string   `json:"title"`
+		Related []string `json:"related"`
+	}{title, related})
+}
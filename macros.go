@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+)
+
+// A macro is text like {{name "arg" arg}} in a page body that is replaced
+// by HTML when the page is rendered. Arguments are words or Go-quoted
+// strings. Text that looks like a call of a macro that does not exist is
+// left as it is.
+type macro func(args []string) (template.HTML, error)
+
+// macros are the known macros, by name. Files add to them with
+// registerMacro in their init functions.
+var macros = make(map[string]macro)
+
+func registerMacro(name string, m macro) {
+	if _, dup := macros[name]; dup {
+		panic("macro " + name + " registered twice")
+	}
+	macros[name] = m
+}
+
+var (
+	macroCall = regexp.MustCompile(`\{\{(\w+)((?:[ \t]+(?:"(?:[^"\\\n]|\\.)*"|[^\s"{}]+))*)[ \t]*\}\}`)
+	macroArg  = regexp.MustCompile(`"(?:[^"\\\n]|\\.)*"|[^\s"{}]+`)
+)
+
+// renderMacros renders text, which is not trusted HTML, replacing the
+// macro calls in it with their output.
+func renderMacros(text []byte) []byte {
+	var out []byte
+	last := 0
+	for _, m := range macroCall.FindAllSubmatchIndex(text, -1) {
+		fn, ok := macros[string(text[m[2]:m[3]])]
+		if !ok {
+			continue
+		}
+		out = append(out, renderText(text[last:m[0]])...)
+		out = append(out, callMacro(string(text[m[2]:m[3]]), fn, string(text[m[4]:m[5]]))...)
+		last = m[1]
+	}
+	return append(out, renderText(text[last:])...)
+}
+
+// callMacro runs fn with the arguments in args. Errors are shown in the
+// page in place of the output.
+func callMacro(name string, fn macro, args string) string {
+	var list []string
+	for _, a := range macroArg.FindAllString(args, -1) {
+		if a[0] == '"' {
+			var err error
+			if a, err = strconv.Unquote(a); err != nil {
+				return macroError(name, err)
+			}
+		}
+		list = append(list, a)
+	}
+	html, err := fn(list)
+	if err != nil {
+		return macroError(name, err)
+	}
+	return string(html)
+}
+
+func macroError(name string, err error) string {
+	return fmt.Sprintf(`<span class="macro-error">%s</span>`, template.HTMLEscapeString(fmt.Sprintf("{{%s}}: %v", name, err)))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+var (
+	mailDomain = flag.String("mail-domain", "", "accept mail to Title@`domain` at /hooks/mail and add it to that page")
+	mailSecret = flag.String("mail-secret", "", "`token` the mail provider must send as ?token= to /hooks/mail")
+)
+
+// maxMailSize bounds the messages accepted by /hooks/mail.
+const maxMailSize = 10 << 20
+
+// Handler for /hooks/mail, the receiver for inbound mail webhooks. The
+// raw message is posted either as the request body (message/rfc822) or
+// in a form field named "email" or "body-mime", as providers differ.
+// Each recipient at -mail-domain names a page; the text of the message
+// becomes the page or is appended to it. Attachments are ignored.
+func mailHookHandler(w http.ResponseWriter, r *http.Request) {
+	if *mailDomain == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(*mailSecret)) != 1 {
+		http.Error(w, "bad token", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxMailSize)
+	var raw io.Reader = r.Body
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "message/rfc822" {
+		if err := r.ParseMultipartForm(maxMailSize); err != nil && err != http.ErrNotMultipart {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		field := r.FormValue("email")
+		if field == "" {
+			field = r.FormValue("body-mime")
+		}
+		raw = strings.NewReader(field)
+	}
+	msg, err := mail.ReadMessage(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	titles := mailTitles(msg.Header)
+	if len(titles) == 0 {
+		http.Error(w, "no recipient at "+*mailDomain, http.StatusUnprocessableEntity)
+		return
+	}
+	text, err := mailText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dec := &mime.WordDecoder{CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		b, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		b, err = decodeCharset(b, charset)
+		return bytes.NewReader(b), err
+	}}
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	// Check every page before changing any, so a message refused for one
+	// recipient is not added to the others when the provider retries it.
+	defer lockPages(titles...)()
+	pages := make([]*Page, len(titles))
+	for i, title := range titles {
+		if pages[i], err = mailPage(r, title, subject, text); err != nil {
+			log.Printf("mail to %s: %v", title, err)
+			http.Error(w, title+": "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	// Once a page is changed, the message must not be retried, so failures
+	// to save the others are reported with a success status.
+	results := make(map[string]string)
+	saved := 0
+	for _, p := range pages {
+		if err := savePage(p); err != nil {
+			log.Printf("mail to %s: %v", p.Title, err)
+			results[p.Title] = err.Error()
+			continue
+		}
+		results[p.Title] = "saved"
+		saved++
+	}
+	switch {
+	case saved == len(pages):
+		w.WriteHeader(http.StatusNoContent)
+	case saved == 0:
+		http.Error(w, "no page could be saved", http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// mailTitles returns the page titles named by recipients at -mail-domain.
+func mailTitles(h mail.Header) []string {
+	var titles []string
+	seen := make(map[string]bool)
+	for _, field := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		addrs, err := h.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			i := strings.LastIndex(a.Address, "@")
+			if i < 0 || !strings.EqualFold(a.Address[i+1:], *mailDomain) {
+				continue
+			}
+			if title := a.Address[:i]; titleValidator.MatchString(title) && !seen[title] {
+				seen[title] = true
+				titles = append(titles, title)
+			}
+		}
+	}
+	return titles
+}
+
+// mailText returns the first text/plain part of a message body.
+func mailText(contentType, encoding string, body io.Reader) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" {
+		mediaType, err = "text/plain", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			// multipart.Reader already undoes quoted-printable.
+			text, err := mailText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err == nil && text != nil {
+				return text, nil
+			}
+		}
+		return nil, errors.New("no text/plain part")
+	}
+	if mediaType != "text/plain" {
+		return nil, nil
+	}
+	text, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if text, err = decodeCharset(text, params["charset"]); err != nil {
+		return nil, err
+	}
+	return bytes.Replace(text, []byte("\r\n"), []byte("\n"), -1), nil
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F of Windows-1252 to the runes
+// they stand for; the other bytes are as in ISO-8859-1.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeCharset returns text in charset as UTF-8. Without conversion
+// tables only UTF-8, US-ASCII, ISO-8859-1 and Windows-1252 are known;
+// other charsets are refused rather than stored garbled.
+func decodeCharset(text []byte, charset string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return bytes.ToValidUTF8(text, []byte("\uFFFD")), nil
+	case "iso-8859-1", "iso8859-1", "latin1":
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		return []byte(string(runes)), nil
+	case "windows-1252", "cp1252":
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+			if b >= 0x80 && b <= 0x9F {
+				runes[i] = windows1252[b-0x80]
+			}
+		}
+		return []byte(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %s", charset)
+}
+
+// mailPage returns the page title with a message added to its end, or a
+// new page if it does not exist. The message goes through the save
+// filters like any other edit.
+func mailPage(r *http.Request, title, subject string, text []byte) (*Page, error) {
+	var body []byte
+	if p, err := loadPage(title); err == nil {
+		body = append(bytes.TrimRight(p.Body, "\n"), "\n\n"...)
+	}
+	if subject != "" {
+		body = append(body, subject+"\n\n"...)
+	}
+	p := &Page{Title: title, Body: append(body, bytes.TrimSpace(text)...)}
+	if err := checkProtected(p); err != nil {
+		return nil, err
+	}
+	if err := checkTrusted(p); err != nil {
+		return nil, err
+	}
+	if v, reason := beforeSave(r, p); v != accept {
+		return nil, errors.New("rejected: " + reason)
+	}
+	return p, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+var frontMatterFence = []byte("---")
+
+// parseFrontMatter splits a Page body into its metadata and content.
+// Metadata is an optional block of "key: value" lines between two "---"
+// lines at the very top of the body:
+//
+//	---
+//	review: 2030-01-31
+//	tags: runbook, ops
+//	---
+//	Page text...
+//
+// Keys are lower-cased. A body without front matter has nil metadata.
+func parseFrontMatter(body []byte) (map[string]string, []byte) {
+	body = bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1)
+	if !bytes.HasPrefix(body, append(frontMatterFence, '\n')) {
+		return nil, body
+	}
+	rest := body[len(frontMatterFence)+1:]
+	meta := make(map[string]string)
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		if bytes.Equal(bytes.TrimSpace(line), frontMatterFence) {
+			return meta, rest
+		}
+		if i := bytes.IndexByte(line, ':'); i > 0 {
+			key := strings.ToLower(strings.TrimSpace(string(line[:i])))
+			meta[key] = strings.TrimSpace(string(line[i+1:]))
+		}
+	}
+	// No closing fence: it was not front matter after all.
+	return nil, body
+}
+
+// pageContent returns body without its front matter.
+func pageContent(body []byte) []byte {
+	_, content := parseFrontMatter(body)
+	return content
+}
+
+// pageMeta returns the metadata of body.
+func pageMeta(body []byte) map[string]string {
+	meta, _ := parseFrontMatter(body)
+	return meta
+}
+
+// setMeta returns body with the metadata key set to value, adding front
+// matter if body has none. Other keys keep their order.
+func setMeta(body []byte, key, value string) []byte {
+	body = bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1)
+	meta, content := parseFrontMatter(body)
+	var lines []string
+	found := false
+	if meta != nil {
+		// Re-read the raw lines, as meta has lost their order.
+		for _, line := range strings.Split(string(body[len(frontMatterFence)+1:len(body)-len(content)]), "\n") {
+			if bytes.Equal(bytes.TrimSpace([]byte(line)), frontMatterFence) {
+				break
+			}
+			if i := strings.IndexByte(line, ':'); i > 0 && strings.ToLower(strings.TrimSpace(line[:i])) == key {
+				if found {
+					continue
+				}
+				line, found = key+": "+value, true
+			}
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, key+": "+value)
+	}
+	var b bytes.Buffer
+	b.Write(frontMatterFence)
+	b.WriteByte('\n')
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.Write(frontMatterFence)
+	b.WriteByte('\n')
+	b.Write(content)
+	return b.Bytes()
+}
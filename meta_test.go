@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		body    string
+		meta    map[string]string
+		content string
+	}{
+		{"Just text\n", nil, "Just text\n"},
+		{"", nil, ""},
+		{"---\nreview: 2030-01-31\nTags:  runbook, ops \n---\nText\n", map[string]string{"review": "2030-01-31", "tags": "runbook, ops"}, "Text\n"},
+		{"---\r\nowner: ops\r\n---\r\nText\r\n", map[string]string{"owner": "ops"}, "Text\n"},
+		{"---\n---\nText", map[string]string{}, "Text"},
+		{"---\nurl: https://example.com:8080/\nnot a pair\n---\n", map[string]string{"url": "https://example.com:8080/"}, ""},
+		{"---\nno closing fence\n", nil, "---\nno closing fence\n"},
+		{"Text\n---\nkey: value\n---\n", nil, "Text\n---\nkey: value\n---\n"},
+		{"----\nkey: value\n---\n", nil, "----\nkey: value\n---\n"},
+	}
+	for _, tt := range tests {
+		meta, content := parseFrontMatter([]byte(tt.body))
+		if !reflect.DeepEqual(meta, tt.meta) || string(content) != tt.content {
+			t.Errorf("parseFrontMatter(%q) = %v, %q; want %v, %q", tt.body, meta, content, tt.meta, tt.content)
+		}
+	}
+}
+
+func TestSetMeta(t *testing.T) {
+	tests := []struct {
+		body, key, value string
+		want             string
+	}{
+		{"Text\n", "tags", "ops", "---\ntags: ops\n---\nText\n"},
+		{"", "owner", "ops", "---\nowner: ops\n---\n"},
+		{"---\nowner: ops\ntags: a\n---\nText\n", "tags", "a, b", "---\nowner: ops\ntags: a, b\n---\nText\n"},
+		{"---\nTags: a\nowner: ops\n---\nText\n", "tags", "b", "---\ntags: b\nowner: ops\n---\nText\n"},
+		{"---\ntags: a\ntags: b\n---\n", "tags", "c", "---\ntags: c\n---\n"},
+		{"---\nowner: ops\n---\nText\n", "review", "2030-01-31", "---\nowner: ops\nreview: 2030-01-31\n---\nText\n"},
+		{"---\r\nowner: ops\r\n---\r\nText\r\n", "owner", "dev", "---\nowner: dev\n---\nText\n"},
+		{"---\nno closing fence\n", "tags", "a", "---\ntags: a\n---\n---\nno closing fence\n"},
+	}
+	for _, tt := range tests {
+		got := string(setMeta([]byte(tt.body), tt.key, tt.value))
+		if got != tt.want {
+			t.Errorf("setMeta(%q, %q, %q) = %q, want %q", tt.body, tt.key, tt.value, got, tt.want)
+		}
+		if meta := pageMeta([]byte(got)); meta[tt.key] != tt.value {
+			t.Errorf("setMeta(%q, %q, %q): %s is %q after", tt.body, tt.key, tt.value, tt.key, meta[tt.key])
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+)
+
+// errMoveExists is returned by movePage when the new title is taken.
+var errMoveExists = errors.New("a page with the new title already exists")
+
+// errMoveBusy is returned by movePage when pages kept gaining links to
+// the page while it waited for their locks.
+var errMoveBusy = errors.New("pages linking to the page keep changing; try again")
+
+// moveTries is how many times movePage locks the pages linking to the
+// page before giving up with errMoveBusy.
+const moveTries = 3
+
+// lockMove locks from, to and the pages linking to from, and returns
+// those pages and the function that unlocks them all. The backlinks are
+// read again once locked, as a page may have gained a link while the
+// locks were taken; then it starts again with that page as well.
+func lockMove(from, to string) ([]string, func(), error) {
+	locked := make(map[string]bool)
+	for _, title := range linkGraph.backlinks(from) {
+		locked[title] = true
+	}
+	for try := 0; try < moveTries; try++ {
+		titles := []string{from, to}
+		for title := range locked {
+			titles = append(titles, title)
+		}
+		unlock := lockPages(titles...)
+		backlinks := linkGraph.backlinks(from)
+		grown := false
+		for _, title := range backlinks {
+			if !locked[title] {
+				locked[title], grown = true, true
+			}
+		}
+		if !grown {
+			return backlinks, unlock, nil
+		}
+		unlock()
+	}
+	return nil, nil, errMoveBusy
+}
+
+// movePage renames the Page from to to and rewrites the links to it in
+// other pages. It returns the titles of the pages whose links changed.
+// If a write fails, the pages written so far are put back as they were.
+// It holds the locks of every page it writes while it works, and does
+// not check the quota, as renaming a page does not make the wiki bigger.
+func movePage(from, to string) ([]string, error) {
+	backlinks, unlock, err := lockMove(from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := os.Stat(pageFile(to)); err == nil {
+		return nil, errMoveExists
+	}
+	p, err := loadPage(from)
+	if err != nil {
+		return nil, err
+	}
+	originals := make(map[string][]byte)
+	var pages []*Page
+	var updated []string
+	for _, title := range backlinks {
+		q, err := loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		originals[title] = q.Body
+		pages = append(pages, &Page{Title: title, Body: rewriteLinks(q.Body, from, to)})
+		updated = append(updated, title)
+	}
+	pages = append(pages, &Page{Title: to, Body: rewriteLinks(p.Body, from, to)})
+
+	var written []*Page
+	undo := func() {
+		for _, q := range written {
+			var err error
+			if body, ok := originals[q.Title]; ok {
+				err = storePage(&Page{Title: q.Title, Body: body})
+			} else {
+				err = deletePage(q.Title)
+			}
+			if err != nil {
+				log.Printf("move %s to %s: undoing %s: %v", from, to, q.Title, err)
+			}
+		}
+	}
+	for _, q := range pages {
+		if err := storePage(q); err != nil {
+			undo()
+			return nil, err
+		}
+		written = append(written, q)
+	}
+	if err := deletePage(from); err != nil {
+		undo()
+		return nil, err
+	}
+	pageEvents.pageMoved(from, to)
+	return updated, nil
+}
+
+// Handler for POST /api/v1/pages/<title>/move. The request body is a
+// JSON object naming the new title, {"to": "NewTitle"}.
+func moveAPIHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !apiAuthorized(w, r) {
+		return
+	}
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !titleValidator.MatchString(req.To) {
+		http.Error(w, "bad title", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(pageFile(title)); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := os.Stat(pageFile(req.To)); err == nil {
+		http.Error(w, req.To+" already exists", http.StatusConflict)
+		return
+	}
+	if scheduledAt(title) != nil {
+		http.Error(w, title+" has a scheduled edit", http.StatusConflict)
+		return
+	}
+	updated, err := movePage(title, req.To)
+	if err == errMoveExists {
+		http.Error(w, req.To+" already exists", http.StatusConflict)
+		return
+	} else if err == errMoveBusy {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if updated == nil {
+		updated = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		From    string   `json:"from"`
+		To      string   `json:"to"`
+		Updated []string `json:"updated"`
+	}{title, req.To, updated})
+}
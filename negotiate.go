@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Representations of a Page served by /view/.
+const (
+	formatHTML = "html"
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// formatExtensions maps URL extensions to formats, so /view/Title.json
+// works where setting an Accept header is awkward.
+var formatExtensions = map[string]string{
+	".html": formatHTML,
+	".txt":  formatText,
+	".md":   formatText,
+	".json": formatJSON,
+}
+
+var formatTypes = map[string]string{
+	"text/html":        formatHTML,
+	"application/json": formatJSON,
+	"text/plain":       formatText,
+	"text/markdown":    formatText,
+	"*/*":              formatHTML,
+	"text/*":           formatHTML,
+}
+
+type formatKey struct{}
+
+// negotiate wraps a handler so that it can tell which representation the
+// client wants with requestFormat. A known extension on the URL is
+// removed and takes precedence over the Accept header.
+func negotiate(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := acceptFormat(r.Header.Get("Accept"))
+		if ext := path.Ext(r.URL.Path); formatExtensions[ext] != "" {
+			format = formatExtensions[ext]
+			u := *r.URL
+			u.Path = strings.TrimSuffix(u.Path, ext)
+			r2 := *r
+			r2.URL = &u
+			r = &r2
+		} else {
+			w.Header().Add("Vary", "Accept")
+		}
+		fn(w, r.WithContext(context.WithValue(r.Context(), formatKey{}, format)))
+	}
+}
+
+// requestFormat returns the format chosen by negotiate, HTML by default.
+func requestFormat(r *http.Request) string {
+	if f, ok := r.Context().Value(formatKey{}).(string); ok {
+		return f
+	}
+	return formatHTML
+}
+
+// acceptFormat returns the format with the highest quality in an Accept
+// header. Earlier media ranges win ties.
+func acceptFormat(accept string) string {
+	best, bestQ := formatHTML, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := formatTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
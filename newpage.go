@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"time"
+)
+
+var templatePrefix = flag.String("template-prefix", "Template", "title `prefix` of the protected pages offered as templates for new pages, as in TemplateMeeting")
+
+var errNoTemplate = errors.New("no such template")
+
+// newPageTemplates returns the names of the templates for new pages, as
+// Meeting for TemplateMeeting. Templates are protected pages whose titles
+// start with -template-prefix, so only the wiki's editors define them.
+// The editor of a page that does not exist offers them, and one chosen
+// with ?template= fills the text area.
+func newPageTemplates() []string {
+	titles, err := listPages()
+	if err != nil || *templatePrefix == "" {
+		return nil
+	}
+	var names []string
+	for _, title := range titles {
+		if !strings.HasPrefix(title, *templatePrefix) || title == *templatePrefix {
+			continue
+		}
+		if name := strings.TrimPrefix(title, *templatePrefix); hasTemplate(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hasTemplate reports whether there is a template for new pages name.
+func hasTemplate(name string) bool {
+	if *templatePrefix == "" || name == "" {
+		return false
+	}
+	p, err := loadPage(*templatePrefix + name)
+	return err == nil && isProtected(p.Body)
+}
+
+// fillTemplate returns the body of a new page title made from the
+// template name at now. In a template,
+//
+//	${title}  is the title of the new page,
+//	${date}   today's date, as in 2006-01-02,
+//	${time}   the time, as in 15:04.
+//
+// The wiki has no accounts, so there is no author to fill in.
+func fillTemplate(name, title string, now time.Time) ([]byte, error) {
+	if !titleValidator.MatchString(name) || !hasTemplate(name) {
+		return nil, errNoTemplate
+	}
+	p, err := loadPage(*templatePrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	// The new page is not protected like its template.
+	body := string(unprotect(p.Body))
+	return []byte(strings.NewReplacer(
+		"${title}", title,
+		"${date}", now.Format("2006-01-02"),
+		"${time}", now.Format("15:04"),
+	).Replace(body)), nil
+}
+
+// unprotect returns body without the protected key in its front matter,
+// for a new page made from a protected one.
+func unprotect(body []byte) []byte {
+	body = bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1)
+	meta, content := parseFrontMatter(body)
+	if meta == nil {
+		return body
+	}
+	var lines []string
+	removed := false
+	for _, line := range strings.Split(string(body[:len(body)-len(content)]), "\n") {
+		if i := strings.IndexByte(line, ':'); i > 0 && strings.ToLower(strings.TrimSpace(line[:i])) == "protected" {
+			removed = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !removed {
+		return body
+	}
+	if len(meta) == 1 {
+		lines = nil // only protected: yes
+	}
+	return []byte(strings.Join(lines, "\n") + string(content))
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestUnprotect(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no front matter", "text\n", "text\n"},
+		{"only protected", "---\nprotected: yes\n---\ntext\n", "text\n"},
+		{"one other key", "---\ntags: ops\n---\ntext\n", "---\ntags: ops\n---\ntext\n"},
+		{"protected and another key", "---\nprotected: yes\ntags: ops\n---\ntext\n", "---\ntags: ops\n---\ntext\n"},
+		{"another key and protected", "---\ntags: ops\nProtected: yes\n---\ntext\n", "---\ntags: ops\n---\ntext\n"},
+		{"CRLF", "---\r\nprotected: yes\r\ntags: ops\r\n---\r\ntext\r\n", "---\ntags: ops\n---\ntext\n"},
+	}
+	for _, tt := range tests {
+		if got := string(unprotect([]byte(tt.body))); got != tt.want {
+			t.Errorf("%s: unprotect = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var notifyFile = flag.String("notify", "", "JSON `file` of chat channels to notify about page changes")
+
+// A notifyChannel is a chat channel told about page changes. The
+// channels file holds a list of them:
+//
+//	[
+//		{"name": "ops", "type": "slack", "url": "https://hooks.slack.com/services/...", "pages": "^Ops"},
+//		{"name": "all", "type": "discord", "url": "https://discord.com/api/webhooks/..."},
+//		{"name": "team", "type": "matrix", "url": "https://matrix.example.org", "room": "!abc:example.org", "token": "..."}
+//	]
+//
+// Pages, if set, is a regular expression a title must match for the
+// channel to hear about it. Queued notifications name their channel, so
+// its URL and token stay in the channels file; a notification for a
+// channel that was removed is dropped. Name defaults to the type and
+// place in the list, as in slack1.
+type notifyChannel struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Room  string `json:"room"`
+	Token string `json:"token"`
+	Pages string `json:"pages"`
+
+	pages *regexp.Regexp
+}
+
+var (
+	notifyChannels []*notifyChannel
+	notifyClient   = &http.Client{Timeout: 10 * time.Second}
+)
+
+func loadNotifyChannels(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var channels []*notifyChannel
+	if err := json.Unmarshal(b, &channels); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	names := make(map[string]bool)
+	for i, c := range channels {
+		if c.Name == "" {
+			c.Name = c.Type + strconv.Itoa(i+1)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("%s: two channels named %q", filename, c.Name)
+		}
+		names[c.Name] = true
+		switch c.Type {
+		case "slack", "discord":
+		case "matrix":
+			if c.Room == "" || c.Token == "" {
+				return fmt.Errorf("%s: matrix channel needs a room and a token", filename)
+			}
+		default:
+			return fmt.Errorf("%s: unknown channel type %q", filename, c.Type)
+		}
+		if c.Pages != "" {
+			if c.pages, err = regexp.Compile(c.Pages); err != nil {
+				return fmt.Errorf("%s: %v", filename, err)
+			}
+		}
+	}
+	notifyChannels = channels
+	return nil
+}
+
+func init() {
+	onPageChanged(notifyChange)
+	registerTask("notify", func(payload json.RawMessage) error {
+		var n notification
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return err
+		}
+		c := notifyChannelNamed(n.Channel)
+		if c == nil {
+			log.Printf("notify: dropping a notification for %s: no channel %q", n.Title, n.Channel)
+			return nil
+		}
+		return c.send(&n)
+	})
+}
+
+// notifyChannelNamed returns the channel name, or nil if there is none.
+func notifyChannelNamed(name string) *notifyChannel {
+	for _, c := range notifyChannels {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// A notification is the task of telling a channel about a change. Diff,
+// if set, links to what changed, and Text, if set, is an alert sent
+// instead of the usual message that the page was updated. Txn is the
+// Matrix transaction ID, fixed when the notification is queued so that
+// a retry is not posted twice.
+type notification struct {
+	Channel     string
+	Title, Link string
+	Diff        string `json:",omitempty"`
+	Text        string `json:",omitempty"`
+	Txn         string `json:",omitempty"`
+}
+
+// notifyChange queues a notification for every channel interested in p,
+// linking to the diff from old.
+func notifyChange(p *Page, old []byte) {
+	channels := interestedChannels(p.Title)
+	if len(channels) == 0 {
+		return
+	}
+	var diff string
+	if id, err := recordChange(p.Title, old, p.Body); err != nil {
+		log.Printf("notify: keeping the change to %s: %v", p.Title, err)
+	} else {
+		diff = absURL("/change/" + id)
+	}
+	queueNotifications(channels, p.Title, diff, "")
+}
+
+// alertChannels queues a notification about title, with text if it is
+// not empty, for every channel interested in it.
+func alertChannels(title, text string) {
+	queueNotifications(interestedChannels(title), title, "", text)
+}
+
+// interestedChannels returns the channels that hear about title.
+func interestedChannels(title string) []*notifyChannel {
+	var channels []*notifyChannel
+	for _, c := range notifyChannels {
+		if c.pages == nil || c.pages.MatchString(title) {
+			channels = append(channels, c)
+		}
+	}
+	return channels
+}
+
+func queueNotifications(channels []*notifyChannel, title, diff, text string) {
+	link := absURL("/view/" + title)
+	for _, c := range channels {
+		n := &notification{Channel: c.Name, Title: title, Link: link, Diff: diff, Text: text}
+		if c.Type == "matrix" {
+			n.Txn = newTxnID()
+		}
+		if err := enqueue("notify", n); err != nil {
+			log.Printf("notify %s: %v", c.Name, err)
+		}
+	}
+}
+
+// newTxnID returns a random Matrix transaction ID.
+func newTxnID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (c *notifyChannel) send(n *notification) error {
+	title, link := n.Title, n.Link
+	text := fmt.Sprintf("%s was updated: %s", title, link)
+	slack := fmt.Sprintf("<%s|%s> was updated.", link, title)
+	if n.Text != "" {
+		text = n.Text + ": " + link
+		slack = fmt.Sprintf("%s: <%s|%s>", n.Text, link, title)
+	} else if n.Diff != "" {
+		text += " (changes: " + n.Diff + ")"
+		slack = fmt.Sprintf("<%s|%s> was updated (<%s|changes>).", link, title, n.Diff)
+	}
+	var req *http.Request
+	var err error
+	switch c.Type {
+	case "slack":
+		req, err = jsonRequest("POST", c.URL, map[string]string{"text": slack})
+	case "discord":
+		req, err = jsonRequest("POST", c.URL, map[string]string{"content": text})
+	case "matrix":
+		endpoint := c.URL + "/_matrix/client/v3/rooms/" + url.PathEscape(c.Room) + "/send/m.room.message/" + url.PathEscape(n.Txn)
+		req, err = jsonRequest("PUT", endpoint, map[string]string{"msgtype": "m.text", "body": text})
+		if err == nil {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func jsonRequest(method, url string, v interface{}) (*http.Request, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// excerptLength is the longest excerpt, in characters.
+const excerptLength = 200
+
+// excerpt is the excerpt template function. It returns the first
+// paragraph of body as plain text, shortened to about excerptLength
+// characters, for use in meta descriptions. Trusted HTML blocks are left
+// out.
+func excerpt(body []byte) string {
+	body = bytes.TrimSpace(trustedBlock.ReplaceAll(pageContent(body), nil))
+	if i := bytes.Index(body, []byte("\n\n")); i >= 0 {
+		body = body[:i]
+	}
+	text := strings.Join(strings.Fields(string(pageLink.ReplaceAll(body, []byte("$1")))), " ")
+	return truncate(excerptLength, text)
+}
+
+var imageLink = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"\]]+\.(?:png|jpe?g|gif|webp)\b`)
+
+// firstImage is the firstImage template function. It returns the first
+// image URL in body, or "" if there is none.
+func firstImage(body []byte) string {
+	return string(imageLink.Find(body))
+}
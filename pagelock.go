@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// pageLocks hold a mutex for each page being changed, so that a change
+// that reads a page and then writes it, like an edit checked against the
+// version it started from, is not interleaved with another change to
+// the same page. A mutex is dropped once nobody holds or waits for it.
+var pageLocks = struct {
+	sync.Mutex
+	m map[string]*pageLock
+}{m: make(map[string]*pageLock)}
+
+type pageLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockPages locks the pages titles and returns the function that
+// unlocks them. The locks are taken in order, so two callers locking the
+// same pages cannot wait for each other.
+func lockPages(titles ...string) (unlock func()) {
+	titles = append([]string(nil), titles...)
+	sort.Strings(titles)
+	var held []string
+	for i, title := range titles {
+		if i > 0 && title == titles[i-1] {
+			continue
+		}
+		pageLocks.Lock()
+		l := pageLocks.m[title]
+		if l == nil {
+			l = &pageLock{}
+			pageLocks.m[title] = l
+		}
+		l.users++
+		pageLocks.Unlock()
+		l.Lock()
+		held = append(held, title)
+	}
+	return func() {
+		pageLocks.Lock()
+		defer pageLocks.Unlock()
+		for _, title := range held {
+			l := pageLocks.m[title]
+			l.Unlock()
+			if l.users--; l.users == 0 {
+				delete(pageLocks.m, title)
+			}
+		}
+	}
+}
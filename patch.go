@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A pageEdit is one step of a JSON patch:
+//
+//	{"op": "append", "text": "..."}                      appends to the page
+//	{"op": "append", "heading": "Notes", "text": "..."}  appends to a section, adding it if missing
+//	{"op": "replace", "heading": "Notes", "text": "..."} replaces the text of a section
+//	{"op": "insert_after", "anchor": "...", "text": "..."} inserts after the line holding anchor
+//
+// A section is a line starting with one or more # and the heading, and
+// the lines up to the next heading of the same or a higher level.
+type pageEdit struct {
+	Op      string `json:"op"`
+	Heading string `json:"heading,omitempty"`
+	Anchor  string `json:"anchor,omitempty"`
+	Text    string `json:"text"`
+}
+
+// errNoMatch is returned when a patch does not fit the page.
+var errNoMatch = errors.New("the patch does not apply")
+
+var headingLine = regexp.MustCompile(`^(#+)\s+(.*?)\s*$`)
+
+// splitLines splits body into lines without their line ends.
+func splitLines(body string) []string {
+	body = strings.Replace(body, "\r\n", "\n", -1)
+	if body == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// findSection returns the line of the heading and the line after the end
+// of its section, or -1, -1 if there is no such heading.
+func findSection(lines []string, heading string) (int, int) {
+	for i, line := range lines {
+		m := headingLine.FindStringSubmatch(line)
+		if m == nil || m[2] != heading {
+			continue
+		}
+		end := i + 1
+		for ; end < len(lines); end++ {
+			if n := headingLine.FindStringSubmatch(lines[end]); n != nil && len(n[1]) <= len(m[1]) {
+				break
+			}
+		}
+		return i, end
+	}
+	return -1, -1
+}
+
+// splice returns lines with lines[i:j] replaced by text.
+func splice(lines []string, i, j int, text string) []string {
+	out := append([]string{}, lines[:i]...)
+	out = append(out, splitLines(text)...)
+	return append(out, lines[j:]...)
+}
+
+// trimBlank returns end moved back over the blank lines ending
+// lines[start:end], which separate a section from the next one.
+func trimBlank(lines []string, start, end int) int {
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return end
+}
+
+// applyEdits returns body with edits made in order.
+func applyEdits(body string, edits []pageEdit) (string, error) {
+	lines := splitLines(body)
+	for n, e := range edits {
+		switch e.Op {
+		case "append":
+			if e.Heading == "" {
+				lines = splice(lines, len(lines), len(lines), e.Text)
+				break
+			}
+			start, end := findSection(lines, e.Heading)
+			if start < 0 {
+				text := "# " + e.Heading + "\n" + e.Text
+				if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+					text = "\n" + text
+				}
+				lines = splice(lines, len(lines), len(lines), text)
+				break
+			}
+			end = trimBlank(lines, start+1, end)
+			lines = splice(lines, end, end, e.Text)
+		case "replace":
+			start, end := findSection(lines, e.Heading)
+			if start < 0 {
+				return "", fmt.Errorf("edit %d: no heading %q", n+1, e.Heading)
+			}
+			lines = splice(lines, start+1, trimBlank(lines, start+1, end), e.Text)
+		case "insert_after":
+			i := 0
+			for ; i < len(lines) && (e.Anchor == "" || !strings.Contains(lines[i], e.Anchor)); i++ {
+			}
+			if e.Anchor == "" || i == len(lines) {
+				return "", fmt.Errorf("edit %d: no line holds %q", n+1, e.Anchor)
+			}
+			lines = splice(lines, i+1, i+1, e.Text)
+		default:
+			return "", fmt.Errorf("edit %d: unknown op %q", n+1, e.Op)
+		}
+	}
+	return joinLines(lines), nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// hunkLen parses the line count of a hunk header, which is 1 when left
+// out.
+func hunkLen(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// applyDiff returns body changed by the unified diff, as made by diff -u
+// or git diff. Hunks must match the page exactly where they say they
+// apply; there is no fuzz.
+func applyDiff(body, diff string) (string, error) {
+	lines := splitLines(body)
+	var out []string
+	next := 0 // the next line of lines not yet copied to out
+	diffLines := splitLines(diff)
+	for i := 0; i < len(diffLines); {
+		m := hunkHeader.FindStringSubmatch(diffLines[i])
+		i++
+		if m == nil {
+			// File headers and other text between hunks.
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		oldLen, newLen := hunkLen(m[2]), hunkLen(m[4])
+		if oldLen > 0 {
+			// Hunks that remove nothing give the line before them.
+			start--
+		}
+		if start < next || start > len(lines) {
+			return "", errNoMatch
+		}
+		out = append(out, lines[next:start]...)
+		next = start
+		for ; oldLen > 0 || newLen > 0; i++ {
+			if i == len(diffLines) {
+				return "", errors.New("diff ends inside a hunk")
+			}
+			line := diffLines[i]
+			if line == "" {
+				// Some editors strip the space of empty context lines.
+				line = " "
+			}
+			switch op, text := line[0], line[1:]; op {
+			case ' ', '-':
+				if next >= len(lines) || lines[next] != text {
+					return "", errNoMatch
+				}
+				if op == ' ' {
+					out = append(out, text)
+					newLen--
+				}
+				oldLen--
+				next++
+			case '+':
+				out = append(out, text)
+				newLen--
+			case '\\':
+				// "\ No newline at end of file"
+			default:
+				return "", fmt.Errorf("bad diff line %q", line)
+			}
+		}
+	}
+	return joinLines(append(out, lines[next:]...)), nil
+}
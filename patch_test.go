@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestApplyEdits(t *testing.T) {
+	const page = "Intro\n\n# Notes\nfirst\n\n## Detail\ndeep\n\n# Links\nlink\n"
+	tests := []struct {
+		name  string
+		body  string
+		edits []pageEdit
+		want  string
+		err   bool
+	}{
+		{"append", "Text\n", []pageEdit{{Op: "append", Text: "more"}}, "Text\nmore\n", false},
+		{"append to empty", "", []pageEdit{{Op: "append", Text: "one\ntwo"}}, "one\ntwo\n", false},
+		{"append to section", page, []pageEdit{{Op: "append", Heading: "Notes", Text: "second"}},
+			"Intro\n\n# Notes\nfirst\n\n## Detail\ndeep\nsecond\n\n# Links\nlink\n", false},
+		{"append to last section", page, []pageEdit{{Op: "append", Heading: "Links", Text: "other"}},
+			"Intro\n\n# Notes\nfirst\n\n## Detail\ndeep\n\n# Links\nlink\nother\n", false},
+		{"append new section", "Text\n", []pageEdit{{Op: "append", Heading: "Notes", Text: "first"}},
+			"Text\n\n# Notes\nfirst\n", false},
+		{"replace section", page, []pageEdit{{Op: "replace", Heading: "Notes", Text: "new"}},
+			"Intro\n\n# Notes\nnew\n\n# Links\nlink\n", false},
+		{"replace subsection", page, []pageEdit{{Op: "replace", Heading: "Detail", Text: "shallow"}},
+			"Intro\n\n# Notes\nfirst\n\n## Detail\nshallow\n\n# Links\nlink\n", false},
+		{"replace missing section", page, []pageEdit{{Op: "replace", Heading: "Nope", Text: "x"}}, "", true},
+		{"insert after", "a\nb\nc\n", []pageEdit{{Op: "insert_after", Anchor: "b", Text: "b2"}}, "a\nb\nb2\nc\n", false},
+		{"insert after missing anchor", "a\n", []pageEdit{{Op: "insert_after", Anchor: "z", Text: "x"}}, "", true},
+		{"insert after no anchor", "a\n", []pageEdit{{Op: "insert_after", Text: "x"}}, "", true},
+		{"in order", "a\n", []pageEdit{{Op: "append", Text: "b"}, {Op: "insert_after", Anchor: "b", Text: "c"}}, "a\nb\nc\n", false},
+		{"unknown op", "a\n", []pageEdit{{Op: "delete"}}, "", true},
+		{"CRLF", "a\r\nb\r\n", []pageEdit{{Op: "append", Text: "c"}}, "a\nb\nc\n", false},
+	}
+	for _, tt := range tests {
+		got, err := applyEdits(tt.body, tt.edits)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: applyEdits = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: applyEdits = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	const page = "one\ntwo\nthree\nfour\nfive\n"
+	tests := []struct {
+		name string
+		body string
+		diff string
+		want string
+		err  error
+	}{
+		{"change", page, "--- a/Page.txt\n+++ b/Page.txt\n@@ -2,3 +2,3 @@\n two\n-three\n+THREE\n four\n",
+			"one\ntwo\nTHREE\nfour\nfive\n", nil},
+		{"two hunks", page, "@@ -1,2 +1,2 @@\n-one\n+ONE\n two\n@@ -4,2 +4,3 @@\n four\n five\n+six\n",
+			"ONE\ntwo\nthree\nfour\nfive\nsix\n", nil},
+		{"insert at start", page, "@@ -0,0 +1 @@\n+zero\n", "zero\n" + page, nil},
+		{"insert after line", page, "@@ -2,0 +3 @@\n+two and a half\n", "one\ntwo\ntwo and a half\nthree\nfour\nfive\n", nil},
+		{"delete", page, "@@ -5 +4,0 @@\n-five\n", "one\ntwo\nthree\nfour\n", nil},
+		{"into empty page", "", "@@ -0,0 +1,2 @@\n+a\n+b\n", "a\nb\n", nil},
+		{"empty context line", "a\n\nb\n", "@@ -1,3 +1,3 @@\n a\n\n-b\n+c\n", "a\n\nc\n", nil},
+		{"no newline at end", "a\n", "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n", "b\n", nil},
+		{"no hunks", page, "just text\n", page, nil},
+		{"stale context", page, "@@ -2,2 +2,2 @@\n two\n-3\n+THREE\n", "", errNoMatch},
+		{"wrong place", page, "@@ -1,1 +1,1 @@\n-two\n+TWO\n", "", errNoMatch},
+		{"past the end", page, "@@ -9,1 +9,1 @@\n-nine\n+NINE\n", "", errNoMatch},
+		{"overlapping hunks", page, "@@ -2 +2 @@\n-two\n+2\n@@ -1 +1 @@\n-one\n+1\n", "", errNoMatch},
+	}
+	for _, tt := range tests {
+		got, err := applyDiff(tt.body, tt.diff)
+		if err != tt.err || got != tt.want {
+			t.Errorf("%s: applyDiff = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+	for _, diff := range []string{"@@ -1,2 +1,2 @@\n one\n", "@@ -1 +1 @@\n*one\n"} {
+		if _, err := applyDiff(page, diff); err == nil || err == errNoMatch {
+			t.Errorf("applyDiff(%q) = %v, want a malformed diff error", diff, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Reader preferences are kept in cookies, as there are no accounts to
+// store them with.
+
+// prefsCookieAge is how long a preference is remembered.
+const prefsCookieAge = 365 * 24 * time.Hour
+
+// setPref stores a preference cookie, or removes it when value is "".
+func setPref(w http.ResponseWriter, name, value string) {
+	c := &http.Cookie{Name: name, Value: value, Path: "/", MaxAge: int(prefsCookieAge / time.Second), HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// pref returns the value of a preference cookie.
+func pref(r *http.Request, name string) string {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// readerWidths and readerFonts are the choices of page width and font
+// size, as CSS values.
+var (
+	readerWidths = map[string]string{"narrow": "35em", "medium": "50em", "wide": "none"}
+	readerFonts  = map[string]string{"small": "14px", "medium": "16px", "large": "20px"}
+)
+
+// readerPrefs are the preferences that change how templates render.
+type readerPrefs struct {
+	Loc         *time.Location
+	Width, Font string
+}
+
+// readerPrefsOf returns the preferences of the reader of r.
+func readerPrefsOf(r *http.Request) readerPrefs {
+	rp := readerPrefs{Loc: viewerLocation(r), Width: pref(r, "width"), Font: pref(r, "font")}
+	if _, ok := readerWidths[rp.Width]; !ok {
+		rp.Width = ""
+	}
+	if _, ok := readerFonts[rp.Font]; !ok {
+		rp.Font = ""
+	}
+	return rp
+}
+
+func (rp readerPrefs) key() string {
+	return rp.Loc.String() + " " + rp.Width + " " + rp.Font
+}
+
+// funcs returns the template functions that depend on the reader: date,
+// which formats times in the reader's time zone, and reader, which gives
+// the CSS values of the chosen width and font size ("" for the theme's
+// own) to the style block in layout.html.
+func (rp readerPrefs) funcs() template.FuncMap {
+	style := struct{ Width, FontSize string }{readerWidths[rp.Width], readerFonts[rp.Font]}
+	return template.FuncMap{
+		"date":   func(layout string, t time.Time) string { return formatDate(layout, t.In(rp.Loc)) },
+		"reader": func() interface{} { return style },
+	}
+}
+
+// Handler for /prefs, where readers choose how the wiki is shown to
+// them.
+func prefsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		tz := r.FormValue("tz")
+		if _, err := time.LoadLocation(tz); err != nil {
+			http.Error(w, tr(r, "unknown time zone %s", tz), http.StatusBadRequest)
+			return
+		}
+		lang := r.FormValue("lang")
+		if _, ok := catalogs[lang]; !ok && lang != "en" {
+			lang = ""
+		}
+		setPref(w, "lang", lang)
+		setPref(w, "tz", tz)
+		for name, choices := range map[string]map[string]string{"width": readerWidths, "font": readerFonts} {
+			v := r.FormValue(name)
+			if _, ok := choices[v]; !ok {
+				v = ""
+			}
+			setPref(w, name, v)
+		}
+		http.Redirect(w, r, "/prefs", http.StatusFound)
+		return
+	}
+	renderTemplate(w, r, "prefs", struct {
+		Lang      string
+		Languages []string
+		TimeZone  string
+		Reader    readerPrefs
+		Widths    []string
+		Fonts     []string
+	}{pref(r, "lang"), languages(), pref(r, "tz"), readerPrefsOf(r), []string{"narrow", "medium", "wide"}, []string{"small", "medium", "large"}})
+}
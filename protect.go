@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// A page is protected by "protected: yes" in its front matter. Protected
+// pages can only be changed through the API, whose clients hold the
+// -api-token and act as the wiki's editors; edits from the web form and
+// by mail are refused. Only API clients can protect or unprotect a page.
+//
+// Protection does not cascade to the pages shown with a page: the
+// Sidebar and Footer pages the templates include through includePage,
+// and the pages whose titles and front matter macros such as pages show,
+// in the view and the print view alike. Those stay open to anyone unless
+// they are protected themselves, as Sidebar and Footer should be on a
+// wiki that protects pages.
+var (
+	errProtected  = errors.New("the page is protected; only editors can change it, through the API")
+	errProtecting = errors.New("only editors can protect pages, through the API")
+)
+
+// isProtected is the protected template function. It reports whether
+// body protects its page.
+func isProtected(body []byte) bool {
+	switch strings.ToLower(pageMeta(body)["protected"]) {
+	case "yes", "true":
+		return true
+	}
+	return false
+}
+
+// checkProtected returns an error if an edit from outside the API may not
+// save p.
+func checkProtected(p *Page) error {
+	if current, err := loadPage(p.Title); err == nil && isProtected(current.Body) {
+		return errProtected
+	}
+	if isProtected(p.Body) {
+		return errProtecting
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	registerMacro("pages", pagesMacro)
+	onPageSaved(pageIndex.saved)
+	onPageDeleted(pageIndex.deleted)
+}
+
+// queryPage is a page as the pages macro sees it.
+type queryPage struct {
+	title    string
+	meta     map[string]string
+	tags     []string
+	modified time.Time
+}
+
+// pageIndex holds every page as the pages macro sees it, so that a query
+// does not read and decrypt every page of the wiki. Like linkGraph it is
+// built at startup and updated as pages are saved. Its queryPages are
+// replaced, never changed, so they can be used without the lock.
+var pageIndex = &metaIndex{}
+
+type metaIndex struct {
+	mu    sync.Mutex
+	pages map[string]*queryPage
+}
+
+// scan reads the front matter of every page in the wiki.
+func (x *metaIndex) scan() error {
+	titles, err := listPages()
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x.pages = make(map[string]*queryPage, len(titles))
+	x.mu.Unlock()
+	for _, title := range titles {
+		p, err := loadPage(title)
+		if err != nil {
+			return err
+		}
+		x.saved(p)
+	}
+	return nil
+}
+
+// saved records the front matter of p.
+func (x *metaIndex) saved(p *Page) {
+	qp := &queryPage{title: p.Title, meta: pageMeta(p.Body), tags: pageTags(p.Body)}
+	if fi, err := os.Stat(pageFile(p.Title)); err == nil {
+		qp.modified = fi.ModTime()
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.pages[p.Title] = qp
+}
+
+// deleted forgets the Page title.
+func (x *metaIndex) deleted(title string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.pages, title)
+}
+
+// all returns every page, sorted by title.
+func (x *metaIndex) all() []*queryPage {
+	x.mu.Lock()
+	pages := make([]*queryPage, 0, len(x.pages))
+	for _, qp := range x.pages {
+		pages = append(pages, qp)
+	}
+	x.mu.Unlock()
+	sort.Slice(pages, func(i, j int) bool { return pages[i].title < pages[j].title })
+	return pages
+}
+
+func (p *queryPage) value(key string) string {
+	switch key {
+	case "title":
+		return p.title
+	case "modified":
+		return p.modified.Format("2006-01-02 15:04")
+	case "tags":
+		return strings.Join(p.tags, ", ")
+	}
+	return p.meta[key]
+}
+
+// pagesMacro is the pages macro, which lists the pages whose metadata
+// match a query:
+//
+//	{{pages tag:runbook sort:modified limit:10}}
+//	{{pages owner:ops show:owner,modified}}
+//
+// Each argument is a key:value pair. tag:name keeps the pages with that
+// tag and any other key the pages whose front matter has that value for
+// it; all must match. sort orders by title, the default, by modified,
+// newest first, or by a front matter key, and a leading - reverses the
+// order. limit keeps the first n pages. show makes a table with columns
+// for the keys given, which may include modified and tags.
+func pagesMacro(args []string) (template.HTML, error) {
+	var (
+		tags  []string
+		match = make(map[string]string)
+		order = "title"
+		limit = -1
+		show  []string
+	)
+	for _, a := range args {
+		i := strings.Index(a, ":")
+		if i <= 0 {
+			return "", fmt.Errorf("want key:value, not %q", a)
+		}
+		key, value := strings.ToLower(a[:i]), a[i+1:]
+		switch key {
+		case "tag":
+			tags = append(tags, value)
+		case "sort":
+			order = strings.ToLower(value)
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("bad limit %q", value)
+			}
+			limit = n
+		case "show":
+			for _, k := range strings.Split(value, ",") {
+				if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+					show = append(show, k)
+				}
+			}
+		default:
+			match[key] = value
+		}
+	}
+	var pages []*queryPage
+	for _, qp := range pageIndex.all() {
+		if qp.matches(tags, match) {
+			pages = append(pages, qp)
+		}
+	}
+	sortQueryPages(pages, order)
+	if limit >= 0 && len(pages) > limit {
+		pages = pages[:limit]
+	}
+	return queryHTML(pages, show), nil
+}
+
+// matches reports whether p has all of tags and the metadata in match.
+func (p *queryPage) matches(tags []string, match map[string]string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range p.tags {
+			if strings.EqualFold(t, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, value := range match {
+		if !strings.EqualFold(p.meta[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortQueryPages(pages []*queryPage, order string) {
+	desc := strings.HasPrefix(order, "-")
+	key := strings.TrimPrefix(order, "-")
+	less := func(a, b *queryPage) bool { return a.value(key) < b.value(key) }
+	switch key {
+	case "title":
+		less = func(a, b *queryPage) bool { return a.title < b.title }
+	case "modified":
+		// Newest first unless reversed.
+		less = func(a, b *queryPage) bool { return a.modified.After(b.modified) }
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		if desc {
+			return less(pages[j], pages[i])
+		}
+		return less(pages[i], pages[j])
+	})
+}
+
+// queryHTML lists pages, or shows them in a table with the columns show.
+func queryHTML(pages []*queryPage, show []string) template.HTML {
+	esc := template.HTMLEscapeString
+	var b strings.Builder
+	link := func(title string) string {
+		return fmt.Sprintf(`<a href="/view/%s">%s</a>`, title, title)
+	}
+	if len(show) == 0 {
+		b.WriteString(`<ul class="pages-query">`)
+		for _, p := range pages {
+			b.WriteString("<li>" + link(p.title) + "</li>")
+		}
+		b.WriteString("</ul>")
+		return template.HTML(b.String())
+	}
+	b.WriteString(`<table class="pages-query"><tr><th></th>`)
+	for _, k := range show {
+		b.WriteString("<th>" + esc(k) + "</th>")
+	}
+	b.WriteString("</tr>")
+	for _, p := range pages {
+		b.WriteString("<tr><td>" + link(p.title) + "</td>")
+		for _, k := range show {
+			b.WriteString("<td>" + esc(p.value(k)) + "</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</table>")
+	return template.HTML(b.String())
+}
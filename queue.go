@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	queueDir     = flag.String("queue-dir", "queue", "`dir` tasks waiting to be run, such as notifications, are kept in")
+	queueWorkers = flag.Int("queue-workers", 4, "`number` of tasks run at the same time")
+)
+
+const (
+	// queueAttempts is how many times a task is tried before it is
+	// given up on and moved to the dead letter directory.
+	queueAttempts = 10
+	// queuePoll is how often workers look for tasks whose retry is due.
+	queuePoll = 5 * time.Second
+)
+
+// A task is slow work, such as sending a notification, that is written to
+// the queue directory before it is run, so it survives a crash or
+// restart. A task that fails is tried again later, waiting longer each
+// time.
+type task struct {
+	ID        string
+	Kind      string
+	Payload   json.RawMessage
+	Attempts  int
+	NotBefore time.Time
+	LastError string `json:",omitempty"`
+}
+
+// taskHandlers run the tasks of each kind.
+var taskHandlers = make(map[string]func(payload json.RawMessage) error)
+
+// registerTask sets the handler of tasks of kind.
+func registerTask(kind string, run func(payload json.RawMessage) error) {
+	if _, dup := taskHandlers[kind]; dup {
+		panic("task " + kind + " registered twice")
+	}
+	taskHandlers[kind] = run
+}
+
+var tasks = &taskQueue{wake: make(chan struct{}, 1), busy: make(map[string]bool)}
+
+type taskQueue struct {
+	mu   sync.Mutex
+	busy map[string]bool // IDs of the tasks being run
+	wake chan struct{}
+}
+
+func init() {
+	registerSpecial("FailedTasks", &specialPage{
+		Description: "Tasks such as notifications that failed too many times and were given up on.",
+		Data:        func(r *http.Request) (interface{}, error) { return tasks.deadReport() },
+	})
+}
+
+func (q *taskQueue) file(id string) string {
+	return filepath.Join(*queueDir, id+".json")
+}
+
+func (q *taskQueue) deadDir() string {
+	return filepath.Join(*queueDir, "dead")
+}
+
+// enqueue adds a task of kind with payload, which is marshalled to JSON.
+func enqueue(kind string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// IDs sort in the order tasks were added.
+	id := fmt.Sprintf("%019d-%04d", time.Now().UnixNano(), rand.Intn(10000))
+	if err := tasks.write(&task{ID: id, Kind: kind, Payload: b}); err != nil {
+		return err
+	}
+	select {
+	case tasks.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *taskQueue) write(t *task) error {
+	if err := os.MkdirAll(*queueDir, 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	// Write and rename, so a crash never leaves half a task.
+	tmp := q.file(t.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.file(t.ID))
+}
+
+// claim returns the oldest task that is due and not being run, and marks
+// it as being run. It returns nil if there is none.
+func (q *taskQueue) claim() (*task, error) {
+	files, err := filepath.Glob(filepath.Join(*queueDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), ".json")
+		if q.busy[id] {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue // taken and finished by another worker
+		}
+		var t task
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if time.Now().Before(t.NotBefore) {
+			continue
+		}
+		q.busy[id] = true
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// run runs t and removes it from the queue, or schedules a retry, or
+// gives up on it.
+func (q *taskQueue) run(t *task) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.busy, t.ID)
+		q.mu.Unlock()
+	}()
+	var err error
+	if fn, ok := taskHandlers[t.Kind]; ok {
+		err = fn(t.Payload)
+	} else {
+		err = fmt.Errorf("unknown task kind %q", t.Kind)
+	}
+	if err == nil {
+		if err := os.Remove(q.file(t.ID)); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	t.Attempts++
+	t.LastError = err.Error()
+	if t.Attempts >= queueAttempts {
+		log.Printf("task %s %s: giving up after %d attempts: %v", t.Kind, t.ID, t.Attempts, err)
+		if err := q.bury(t); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	backoff := time.Duration(1<<uint(t.Attempts)) * 10 * time.Second
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	t.NotBefore = time.Now().Add(backoff).UTC()
+	log.Printf("task %s %s: attempt %d failed, retrying in %s: %v", t.Kind, t.ID, t.Attempts, backoff, err)
+	if err := q.write(t); err != nil {
+		log.Print(err)
+	}
+}
+
+// bury moves t to the dead letter directory, where it is kept for an
+// administrator to look at.
+func (q *taskQueue) bury(t *task) error {
+	if err := os.MkdirAll(q.deadDir(), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(q.deadDir(), t.ID+".json"), b, 0600); err != nil {
+		return err
+	}
+	return os.Remove(q.file(t.ID))
+}
+
+// work runs tasks as they come due.
+func (q *taskQueue) work() {
+	for {
+		t, err := q.claim()
+		if err != nil {
+			log.Print(err)
+		}
+		if t != nil {
+			q.run(t)
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-time.After(queuePoll):
+		}
+	}
+}
+
+// startQueue starts the -queue-workers workers. Tasks left in the queue
+// by an earlier run are picked up again.
+func startQueue() {
+	for i := 0; i < *queueWorkers; i++ {
+		go tasks.work()
+	}
+}
+
+func (q *taskQueue) deadReport() ([]specialItem, error) {
+	files, err := filepath.Glob(filepath.Join(q.deadDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var items []specialItem
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var t task
+		if err := json.Unmarshal(b, &t); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, specialItem{Name: t.Kind + " " + t.ID, URL: "#" + t.ID, Note: t.LastError, Time: fi.ModTime()})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+	return items, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+var quota = flag.Int("quota", 0, "maximum total size of all pages in `bytes`, or 0 for no limit")
+
+// A quotaError is returned for saves that would take the wiki over
+// -quota.
+type quotaError struct {
+	want, quota int
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf(quotaMessage, e.want, e.quota)
+}
+
+const quotaMessage = "storage quota exceeded: the wiki would use %d of %d bytes"
+
+func (e *quotaError) message() (string, []interface{}) {
+	return quotaMessage, []interface{}{e.want, e.quota}
+}
+
+// saveStatus returns the HTTP status for err, an error from savePage:
+// 507 if the wiki is full and 500 otherwise.
+func saveStatus(err error) int {
+	if _, ok := err.(*quotaError); ok {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// checkQuota returns a *quotaError if saving p would take the wiki over
+// -quota. Pages that shrink are always accepted, so a full wiki can be
+// trimmed. savePage checks it, so callers need not.
+func checkQuota(p *Page) error {
+	if *quota <= 0 {
+		return nil
+	}
+	stats.mu.Lock()
+	used := stats.bytes
+	want := used - stats.pages[p.Title].bytes + len(p.Body)
+	stats.mu.Unlock()
+	if want > *quota && want > used {
+		return &quotaError{want, *quota}
+	}
+	return nil
+}
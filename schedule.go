@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var scheduleDir = flag.String("schedule-dir", "scheduled", "`dir` edits waiting to be published are kept in")
+
+// A scheduledEdit is an edit saved with a publish time in the future. The
+// current version of the page is served until the scheduler publishes it.
+type scheduledEdit struct {
+	Title     string
+	Body      []byte
+	PublishAt time.Time
+	// ETag is the ETag of the page when the edit was scheduled, or ""
+	// if there was no page. The edit is only published over that version.
+	ETag string
+}
+
+// errScheduledStale is why a scheduled edit is not published when its
+// page has changed since the edit was scheduled.
+var errScheduledStale = errors.New("the page has changed since the edit was scheduled")
+
+func scheduledFile(title string) string {
+	return filepath.Join(*scheduleDir, title+".json")
+}
+
+// scheduleEdit holds p back until at. A page has at most one scheduled
+// edit; a later one replaces it. The caller holds the page's lock.
+func scheduleEdit(p *Page, at time.Time) error {
+	if err := os.MkdirAll(*scheduleDir, 0700); err != nil {
+		return err
+	}
+	etag := ""
+	if current, err := loadPage(p.Title); err == nil {
+		etag = pageETag(current.Body)
+	}
+	body, err := sealBody(p.Body)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&scheduledEdit{Title: p.Title, Body: body, PublishAt: at.UTC(), ETag: etag})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(scheduledFile(p.Title), b, 0600)
+}
+
+func loadScheduled(filename string) (*scheduledEdit, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var e scheduledEdit
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	e.Body, err = openBody(e.Body)
+	return &e, err
+}
+
+// scheduledAt is the scheduledAt template function. It returns when the
+// scheduled edit of title will be published, or nil if there is none.
+func scheduledAt(title string) *time.Time {
+	e, err := loadScheduled(scheduledFile(title))
+	if err != nil {
+		return nil
+	}
+	return &e.PublishAt
+}
+
+// publishDue publishes the scheduled edits whose time has come. An edit
+// that cannot be published is kept and tried again next time, without
+// holding up the others; the first such error is returned.
+func publishDue() error {
+	files, err := filepath.Glob(filepath.Join(*scheduleDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, f := range files {
+		title := strings.TrimSuffix(filepath.Base(f), ".json")
+		if !titleValidator.MatchString(title) {
+			continue
+		}
+		if err := publishScheduled(f, title); err != nil {
+			log.Printf("publish scheduled edit of %s: %v", title, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// publishScheduled publishes the edit of title scheduled in f if it is
+// due, and removes f once it is. The edit is checked again as it is
+// published, under the page's lock: if the page has changed since, or
+// has become protected, or the edit would change its trusted HTML
+// blocks, f is moved aside to f.rejected and nothing is saved.
+func publishScheduled(f, title string) error {
+	defer lockPages(title)()
+	e, err := loadScheduled(f)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(e.PublishAt) {
+		return nil
+	}
+	p := &Page{Title: title, Body: e.Body}
+	etag := ""
+	if current, err := loadPage(title); err == nil {
+		etag = pageETag(current.Body)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if etag != e.ETag {
+		err = errScheduledStale
+	} else if err = checkProtected(p); err == nil {
+		err = checkTrusted(p)
+	}
+	if err != nil {
+		log.Printf("rejected scheduled edit of %s: %v", title, err)
+		return os.Rename(f, f+".rejected")
+	}
+	if err := savePage(p); err != nil {
+		return err
+	}
+	log.Printf("published scheduled edit of %s", title)
+	return os.Remove(f)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPublishDue(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		edit    string
+		between func() error // what happens to the page after scheduling
+		due     bool
+		want    string // the page after publishDue
+		status  string // "published", "kept" or "rejected"
+	}{
+		{"unchanged", "one\n", "two\n", nil, true, "two\n", "published"},
+		{"new page", "", "two\n", nil, true, "two\n", "published"},
+		{"not due", "one\n", "two\n", nil, false, "one\n", "kept"},
+		{"changed", "one\n", "two\n", func() error { return savePage(&Page{Title: "Page", Body: []byte("three\n")}) }, true, "three\n", "rejected"},
+		{"protected", "one\n", "two\n", func() error {
+			return savePage(&Page{Title: "Page", Body: []byte("---\nprotected: yes\n---\none\n")})
+		}, true, "---\nprotected: yes\n---\none\n", "rejected"},
+		{"trusted block added", "one\n", "two\n", func() error {
+			return savePage(&Page{Title: "Page", Body: []byte("one\n{{html}}\n<b>x</b>\n{{/html}}\n")})
+		}, true, "one\n{{html}}\n<b>x</b>\n{{/html}}\n", "rejected"},
+		{"deleted", "one\n", "two\n", func() error { return deletePage("Page") }, true, "", "rejected"},
+		{"created", "", "two\n", func() error { return savePage(&Page{Title: "Page", Body: []byte("other\n")}) }, true, "other\n", "rejected"},
+		// Edits the web form could not save are refused at publish time
+		// too, even on an unchanged page.
+		{"protecting", "one\n", "---\nprotected: yes\n---\ntwo\n", nil, true, "one\n", "rejected"},
+		{"adding a trusted block", "one\n", "{{html}}\n<b>x</b>\n{{/html}}\n", nil, true, "one\n", "rejected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := map[string]string{}
+			if tt.page != "" {
+				pages["Page"] = tt.page
+			}
+			testWiki(t, pages)
+			at := time.Now().Add(-time.Minute)
+			if !tt.due {
+				at = time.Now().Add(time.Hour)
+			}
+			if err := scheduleEdit(&Page{Title: "Page", Body: []byte(tt.edit)}, at); err != nil {
+				t.Fatal(err)
+			}
+			if tt.between != nil {
+				if err := tt.between(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := publishDue(); err != nil {
+				t.Fatal(err)
+			}
+			if body := pageBody(t, "Page"); body != tt.want {
+				t.Errorf("page is %q, want %q", body, tt.want)
+			}
+			status := "published"
+			if scheduledAt("Page") != nil {
+				status = "kept"
+			} else if _, err := os.Stat(scheduledFile("Page") + ".rejected"); err == nil {
+				status = "rejected"
+			}
+			if status != tt.status {
+				t.Errorf("edit %s, want %s", status, tt.status)
+			}
+		})
+	}
+}
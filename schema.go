@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var schemaFile = flag.String("schemas", "", "JSON `file` of page schemas, which give pages a form in the editor")
+
+// A pageSchema gives the pages whose titles match Pages a form in the
+// editor, for structured pages such as runbooks or inventories. The
+// schemas file holds a list of them:
+//
+//	[
+//		{"name": "runbook", "pages": "^Runbook", "layout": "runbook", "fields": [
+//			{"name": "service", "label": "Service", "required": true},
+//			{"name": "severity", "type": "select", "options": ["low", "high"]},
+//			{"name": "reviewed", "type": "date"}
+//		]}
+//	]
+//
+// Titles have no namespaces, so a title pattern stands in for one. Field
+// values are kept in the page's front matter, and the editor shows the
+// rest of the page in a text area below the form. Pages of a schema are
+// viewed with its layout, view-<layout>.html, unless their front matter
+// names another, and the meta template function gives it the fields.
+type pageSchema struct {
+	Name   string         `json:"name"`
+	Pages  string         `json:"pages"`
+	Layout string         `json:"layout"`
+	Fields []*schemaField `json:"fields"`
+
+	pages *regexp.Regexp
+}
+
+// A schemaField is one field of a schema's form. Type is text, the
+// default, number, date, url, checkbox or select, which takes one of
+// Options.
+type schemaField struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options"`
+}
+
+var (
+	pageSchemas []*pageSchema
+	fieldName   = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+func loadSchemas(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var schemas []*pageSchema
+	if err := json.Unmarshal(b, &schemas); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, s := range schemas {
+		if s.Pages == "" {
+			return fmt.Errorf("%s: schema %q needs pages", filename, s.Name)
+		}
+		if s.pages, err = regexp.Compile(s.Pages); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		for _, f := range s.Fields {
+			if !fieldName.MatchString(f.Name) {
+				return fmt.Errorf("%s: schema %q: bad field name %q", filename, s.Name, f.Name)
+			}
+			switch f.Type {
+			case "":
+				f.Type = "text"
+			case "text", "number", "date", "url", "checkbox":
+			case "select":
+				if len(f.Options) == 0 {
+					return fmt.Errorf("%s: schema %q: select field %q needs options", filename, s.Name, f.Name)
+				}
+			default:
+				return fmt.Errorf("%s: schema %q: field %q has unknown type %q", filename, s.Name, f.Name, f.Type)
+			}
+			if f.Label == "" {
+				f.Label = f.Name
+			}
+		}
+	}
+	pageSchemas = schemas
+	return nil
+}
+
+// schemaFor returns the schema of the page title, or nil if it has none.
+// The first schema whose pattern matches wins.
+func schemaFor(title string) *pageSchema {
+	for _, s := range pageSchemas {
+		if s.pages.MatchString(title) {
+			return s
+		}
+	}
+	return nil
+}
+
+// A schemaInput is a field of the editor's form with its current value.
+type schemaInput struct {
+	*schemaField
+	Value string
+}
+
+// inputs returns the fields of s with their values in body.
+func (s *pageSchema) inputs(body []byte) []schemaInput {
+	meta := pageMeta(body)
+	inputs := make([]schemaInput, len(s.Fields))
+	for i, f := range s.Fields {
+		inputs[i] = schemaInput{f, meta[f.Name]}
+	}
+	return inputs
+}
+
+// build returns the body of a page of s saved from the editor: the front
+// matter of current, the page as it was, with the fields set from the
+// form, and the text of the form's text area.
+func (s *pageSchema) build(r *http.Request, current []byte) ([]byte, error) {
+	current = bytes.Replace(current, []byte("\r\n"), []byte("\n"), -1)
+	meta, content := parseFrontMatter(current)
+	var body []byte
+	if meta != nil {
+		// Keep the keys the form does not show, such as tags.
+		body = current[:len(current)-len(content)]
+	}
+	body = append(append([]byte(nil), body...), strings.Replace(r.FormValue("body"), "\r\n", "\n", -1)...)
+	for _, f := range s.Fields {
+		v, err := f.value(r.FormValue("field." + f.Name))
+		if err != nil {
+			return nil, err
+		}
+		body = setMeta(body, f.Name, v)
+	}
+	return body, nil
+}
+
+// value checks and returns the value of f given in the form.
+func (f *schemaField) value(v string) (string, error) {
+	v = strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(v))
+	if f.Type == "checkbox" {
+		if v != "" {
+			return "yes", nil
+		}
+		return "no", nil
+	}
+	if v == "" {
+		if f.Required {
+			return "", newUserError("%s is required", f.Label)
+		}
+		return "", nil
+	}
+	switch f.Type {
+	case "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "", newUserError("%s: not a number", f.Label)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return "", newUserError("%s: not a date such as 2006-01-31", f.Label)
+		}
+	case "url":
+		if u, err := url.Parse(v); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return "", newUserError("%s: not a web address", f.Label)
+		}
+	case "select":
+		for _, o := range f.Options {
+			if v == o {
+				return v, nil
+			}
+		}
+		return "", newUserError("%s: not one of %s", f.Label, strings.Join(f.Options, ", "))
+	}
+	return v, nil
+}
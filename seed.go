@@ -0,0 +1,68 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	seedTitles = flag.String("seed", "FrontPage,Help,Sandbox", "comma separated `titles` of the pages written into a wiki with no pages on startup; empty for none")
+	seedDir    = flag.String("seed-dir", "", "`directory` of Title.txt files to seed pages from instead of the built-in ones")
+)
+
+// seedFiles holds the built-in seed pages.
+//
+//go:embed seed/*.txt
+var seedFiles embed.FS
+
+// seedPages writes the -seed pages if the wiki has no pages yet, so a
+// new wiki opens on its front page rather than an empty editor. A page
+// is read from -seed-dir if it is there, and otherwise from the pages
+// built into the program. It runs before the indexes are built, so the
+// pages are saved without being announced.
+func seedPages() error {
+	titles, err := listPages()
+	if err != nil || len(titles) > 0 || *seedTitles == "" {
+		return err
+	}
+	var pages []*Page
+	for _, title := range strings.Split(*seedTitles, ",") {
+		title = strings.TrimSpace(title)
+		if !titleValidator.MatchString(title) {
+			return fmt.Errorf("-seed: bad title %q", title)
+		}
+		body, err := seedBody(title)
+		if err != nil {
+			return fmt.Errorf("-seed: %v", err)
+		}
+		pages = append(pages, &Page{Title: title, Body: body})
+	}
+	for _, p := range pages {
+		if err := p.save(); err != nil {
+			return err
+		}
+	}
+	log.Printf("seeded %d pages", len(pages))
+	return nil
+}
+
+// seedBody returns the seed page title.
+func seedBody(title string) ([]byte, error) {
+	if *seedDir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(*seedDir, pageFile(title)))
+		if !os.IsNotExist(err) {
+			return b, err
+		}
+	}
+	b, err := seedFiles.ReadFile("seed/" + pageFile(title))
+	if err != nil {
+		return nil, fmt.Errorf("no seed page %s", title)
+	}
+	return b, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	baseURL    = flag.String("base-url", "", "public `URL` of the wiki, used in sitemap.xml (default taken from requests)")
+	robotsFile = flag.String("robots", "", "serve robots.txt from `file` instead of the default")
+)
+
+// siteURL returns the public URL of the wiki with no trailing slash.
+func siteURL(r *http.Request) string {
+	if *baseURL != "" {
+		return strings.TrimSuffix(*baseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// absURL is the absURL template function. Templates do not see the
+// request, so path is only made absolute when -base-url is set.
+func absURL(path string) string {
+	return strings.TrimSuffix(*baseURL, "/") + path
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Handler for /sitemap.xml. Pages are dated by the modification time of
+// their files.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	base := siteURL(r)
+	urls := make([]sitemapURL, len(titles))
+	for i, title := range titles {
+		urls[i].Loc = base + "/view/" + title
+		if fi, err := os.Stat(pageFile(title)); err == nil {
+			urls[i].LastMod = fi.ModTime().UTC().Format("2006-01-02T15:04:05Z")
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	enc.Encode(struct {
+		XMLName xml.Name     `xml:"urlset"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		URLs    []sitemapURL `xml:"url"`
+	}{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls})
+}
+
+// Handler for /robots.txt. By default crawlers are kept out of everything
+// but the pages themselves and pointed at the sitemap.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if *robotsFile != "" {
+		b, err := ioutil.ReadFile(*robotsFile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(b)
+		return
+	}
+	fmt.Fprintf(w, "User-agent: *\nDisallow: /edit/\nDisallow: /save/\nDisallow: /api/\n\nSitemap: %s/sitemap.xml\n", siteURL(r))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	spamMaxLinks   = flag.Int("spam-max-links", 0, "reject pages with more than `n` external links (0 disables)")
+	spamRulesFile  = flag.String("spam-rules", "", "`file` of blocked domain: entries and regular expressions")
+	spamQuarantine = flag.String("spam-quarantine", "", "hold rejected saves in `dir` instead of discarding them")
+)
+
+// A verdict is the outcome of running a Page through the save filters.
+// Larger verdicts win.
+type verdict int
+
+const (
+	accept verdict = iota
+	quarantine
+	reject
+)
+
+// A saveFilter inspects a Page before it is saved and returns a verdict
+// along with a reason for anything other than accept.
+type saveFilter func(r *http.Request, p *Page) (verdict, string)
+
+// saveFilters run in order on every save. Plugins add to the chain with
+// registerSaveFilter.
+var saveFilters []saveFilter
+
+func registerSaveFilter(f saveFilter) {
+	saveFilters = append(saveFilters, f)
+}
+
+// beforeSave runs the filter chain and returns the strictest verdict.
+// The chain stops at the first reject.
+func beforeSave(r *http.Request, p *Page) (verdict, string) {
+	v, reason := accept, ""
+	for _, f := range saveFilters {
+		if fv, fr := f(r, p); fv > v {
+			v, reason = fv, fr
+			if v == reject {
+				break
+			}
+		}
+	}
+	return v, reason
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// maxLinksFilter rejects pages with more than max external links.
+func maxLinksFilter(max int) saveFilter {
+	return func(r *http.Request, p *Page) (verdict, string) {
+		if n := len(linkPattern.FindAll(p.Body, -1)); n > max {
+			return reject, fmt.Sprintf("too many links (%d > %d)", n, max)
+		}
+		return accept, ""
+	}
+}
+
+// rulesFilter rejects pages linking to a blocked domain or matching a
+// blocked regular expression. The rules file holds one rule per line:
+//
+//	domain:spam.example
+//	(?i)cheap\s+pills
+func rulesFilter(filename string) (saveFilter, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var domains []string
+	var patterns []*regexp.Regexp
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "domain:"):
+			domains = append(domains, strings.ToLower(line[len("domain:"):]))
+		default:
+			re, err := regexp.Compile(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, n, err)
+			}
+			patterns = append(patterns, re)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return func(r *http.Request, p *Page) (verdict, string) {
+		for _, link := range linkPattern.FindAll(p.Body, -1) {
+			u, err := url.Parse(string(link))
+			if err != nil {
+				continue
+			}
+			host := strings.ToLower(u.Hostname())
+			for _, d := range domains {
+				if host == d || strings.HasSuffix(host, "."+d) {
+					return reject, "links to blocked domain " + d
+				}
+			}
+		}
+		for _, re := range patterns {
+			if re.Match(p.Body) {
+				return reject, "matches blocked pattern " + re.String()
+			}
+		}
+		return accept, ""
+	}, nil
+}
+
+// quarantinePage writes p to the quarantine directory for a maintainer to
+// look at later.
+func quarantinePage(p *Page) error {
+	if err := os.MkdirAll(*spamQuarantine, 0700); err != nil {
+		return err
+	}
+	filename := filepath.Join(*spamQuarantine, p.Title+"-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".txt")
+	body, err := sealBody(p.Body)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, body, 0600)
+}
+
+// setupSpamFilters registers the built-in filters selected by flags.
+// When a quarantine directory is set, rejects become quarantines.
+func setupSpamFilters() error {
+	var builtin []saveFilter
+	if *spamMaxLinks > 0 {
+		builtin = append(builtin, maxLinksFilter(*spamMaxLinks))
+	}
+	if *spamRulesFile != "" {
+		f, err := rulesFilter(*spamRulesFile)
+		if err != nil {
+			return err
+		}
+		builtin = append(builtin, f)
+	}
+	for _, f := range builtin {
+		f := f
+		if *spamQuarantine != "" {
+			registerSaveFilter(func(r *http.Request, p *Page) (verdict, string) {
+				v, reason := f(r, p)
+				if v == reject {
+					v = quarantine
+				}
+				return v, reason
+			})
+			continue
+		}
+		registerSaveFilter(f)
+	}
+	return nil
+}
+
+// filterSave runs the save filters and reports whether the save should go
+// ahead. Otherwise the response has already been written. Without a
+// quarantine directory there is nowhere to hold a page, so it is rejected.
+func filterSave(w http.ResponseWriter, r *http.Request, p *Page) bool {
+	v, reason := beforeSave(r, p)
+	if v == quarantine && *spamQuarantine == "" {
+		v = reject
+	}
+	switch v {
+	case quarantine:
+		if err := quarantinePage(p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		log.Printf("quarantined save of %s from %s: %s", p.Title, r.RemoteAddr, reason)
+		http.Error(w, tr(r, "Your edit has been held for review."), http.StatusAccepted)
+		return false
+	case reject:
+		log.Printf("rejected save of %s from %s: %s", p.Title, r.RemoteAddr, reason)
+		http.Error(w, tr(r, "Your edit was rejected as spam: %s", reason), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A specialPage is a page generated by the wiki rather than written by
+// its users, such as a list of every page. Special pages live under
+// /special/<name> and are added with registerSpecial.
+type specialPage struct {
+	Description string
+	// Template renders the result of Data. The default, special.html,
+	// expects a []specialItem and renders it as a list of links.
+	Template string
+	Data     func(r *http.Request) (interface{}, error)
+}
+
+// A specialItem is one entry of a special page listing. Time, if set,
+// is shown in the reader's time zone after the note.
+type specialItem struct {
+	Name, URL, Note string
+	Time            time.Time
+}
+
+// pageItem returns a specialItem linking to the Page title.
+func pageItem(title, note string) specialItem {
+	return specialItem{Name: title, URL: "/view/" + title, Note: note}
+}
+
+var specialPages = make(map[string]*specialPage)
+
+func registerSpecial(name string, p *specialPage) {
+	if _, dup := specialPages[name]; dup {
+		panic("special page " + name + " registered twice")
+	}
+	if p.Template == "" {
+		p.Template = "special"
+	}
+	specialPages[name] = p
+}
+
+func init() {
+	registerSpecial("AllPages", &specialPage{
+		Description: "Every page in the wiki.",
+		Data:        allPages,
+	})
+	registerSpecial("RecentChanges", &specialPage{
+		Description: "The most recently changed pages.",
+		Data:        recentChanges,
+	})
+}
+
+// Handler for /special/<name>. /special/ lists the special pages.
+func specialHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/special/")
+	if name == "" {
+		renderTemplate(w, r, "special", struct {
+			Title, Description string
+			Data               []specialItem
+		}{"Special pages", "Pages generated by the wiki.", specialIndex()})
+		return
+	}
+	p, ok := specialPages[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := p.Data(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, p.Template, struct {
+		Title, Description string
+		Data               interface{}
+	}{name, p.Description, data})
+}
+
+func specialIndex() []specialItem {
+	var items []specialItem
+	for name, p := range specialPages {
+		items = append(items, specialItem{Name: name, URL: "/special/" + name, Note: p.Description})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+func allPages(r *http.Request) (interface{}, error) {
+	titles, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]specialItem, len(titles))
+	for i, title := range titles {
+		items[i] = pageItem(title, "")
+	}
+	return items, nil
+}
+
+// recentChangesLimit is the number of pages shown by RecentChanges.
+const recentChangesLimit = 50
+
+// A change is the last modification of a Page.
+type change struct {
+	Title string
+	Mod   time.Time
+}
+
+// recentPages returns up to limit pages, most recently modified first.
+// Pages are dated by the modification time of their files, as gowiki
+// keeps no revision log.
+func recentPages(limit int) ([]change, error) {
+	titles, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	var changes []change
+	for _, title := range titles {
+		fi, err := os.Stat(pageFile(title))
+		if err != nil {
+			continue
+		}
+		changes = append(changes, change{title, fi.ModTime()})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Mod.After(changes[j].Mod) })
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+func recentChanges(r *http.Request) (interface{}, error) {
+	changes, err := recentPages(recentChangesLimit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]specialItem, len(changes))
+	for i, c := range changes {
+		items[i] = pageItem(c.Title, "")
+		items[i].Time = c.Mod
+	}
+	return items, nil
+}
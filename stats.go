@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stats holds the figures shown on Special:Statistics. It is filled by a
+// single scan at startup and then kept up to date as pages are saved, so
+// requests never have to read every page.
+var stats = &wikiStats{}
+
+// largestPagesLimit is the number of pages in the largest pages list.
+const largestPagesLimit = 10
+
+type pageStats struct {
+	words, bytes int
+}
+
+type wikiStats struct {
+	mu    sync.Mutex
+	pages map[string]pageStats
+	words int
+	bytes int
+	// edits counts saves per day since the server started.
+	edits map[string]int
+}
+
+func init() {
+	onPageSaved(stats.saved)
+	onPageDeleted(stats.deleted)
+	registerSpecial("Statistics", &specialPage{
+		Description: "Figures about the wiki and its pages.",
+		Template:    "statistics",
+		Data:        func(r *http.Request) (interface{}, error) { return stats.snapshot(), nil },
+	})
+}
+
+// scan counts every page in the wiki.
+func (s *wikiStats) scan() error {
+	titles, err := listPages()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages = make(map[string]pageStats, len(titles))
+	s.edits = make(map[string]int)
+	s.words, s.bytes = 0, 0
+	for _, title := range titles {
+		p, err := loadPage(title)
+		if err != nil {
+			return err
+		}
+		s.set(p)
+	}
+	return nil
+}
+
+func (s *wikiStats) set(p *Page) {
+	ps := pageStats{words: len(bytes.Fields(p.Body)), bytes: len(p.Body)}
+	s.words += ps.words - s.pages[p.Title].words
+	s.bytes += ps.bytes - s.pages[p.Title].bytes
+	s.pages[p.Title] = ps
+}
+
+// saved records a save of p.
+func (s *wikiStats) saved(p *Page) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(p)
+	s.edits[time.Now().Format("2006-01-02")]++
+}
+
+// deleted forgets the Page title.
+func (s *wikiStats) deleted(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.words -= s.pages[title].words
+	s.bytes -= s.pages[title].bytes
+	delete(s.pages, title)
+}
+
+// A dayCount is one point of a per-day series.
+type dayCount struct {
+	Day   string
+	Count int
+}
+
+type statsSnapshot struct {
+	Pages, Words int
+	Bytes, Quota int
+	Largest      []specialItem
+	EditsPerDay  []dayCount
+}
+
+func (s *wikiStats) snapshot() *statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &statsSnapshot{Pages: len(s.pages), Words: s.words, Bytes: s.bytes, Quota: *quota}
+	titles := make([]string, 0, len(s.pages))
+	for title := range s.pages {
+		titles = append(titles, title)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		a, b := s.pages[titles[i]], s.pages[titles[j]]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return titles[i] < titles[j]
+	})
+	if len(titles) > largestPagesLimit {
+		titles = titles[:largestPagesLimit]
+	}
+	for _, title := range titles {
+		ps := s.pages[title]
+		snap.Largest = append(snap.Largest, pageItem(title, fmt.Sprintf("%d bytes, %d words", ps.bytes, ps.words)))
+	}
+	for day, n := range s.edits {
+		snap.EditsPerDay = append(snap.EditsPerDay, dayCount{day, n})
+	}
+	sort.Slice(snap.EditsPerDay, func(i, j int) bool { return snap.EditsPerDay[i].Day < snap.EditsPerDay[j].Day })
+	return snap
+}
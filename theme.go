@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	themeName = flag.String("theme", "", "`name` of a theme in the themes directory to use instead of the default look")
+	devMode   = flag.Bool("dev", false, "parse the templates again for every request, for working on a theme")
+)
+
+// themesDir holds one directory per theme.
+const themesDir = "themes"
+
+// templateFiles are the templates the wiki renders. A theme may replace
+// any of them; those it leaves out fall back to the defaults in the
+// working directory.
+var templateFiles = []string{"edit.html", "view.html", "special.html", "statistics.html", "prefs.html", "print.html", "graph.html", "diff.html", "calendar.html", "layout.html"}
+
+// themeDir returns the path of name inside the active theme, or inside
+// the working directory when no theme is selected.
+func themeDir(name string) string {
+	if *themeName == "" {
+		return name
+	}
+	return filepath.Join(themesDir, *themeName, name)
+}
+
+// parseTemplates parses the templates for the language lang: the
+// default templates and layouts and then the active theme's, so a
+// theme's edit.html replaces the default edit.html. Their t function
+// translates messages with the catalog of lang.
+func parseTemplates(lang string) (*template.Template, error) {
+	layouts, err := filepath.Glob(layoutTemplate("*") + ".html")
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New("").Funcs(templateFuncs).Funcs(template.FuncMap{
+		"t":   catalogs[lang].translate,
+		"ago": catalogs[lang].ago,
+	}).Funcs(readerPrefs{Loc: time.Local}.funcs()).ParseFiles(append(templateFiles, layouts...)...)
+	if err != nil || *themeName == "" {
+		return t, err
+	}
+	if _, err := os.Stat(themeDir("")); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(themeDir("*.html"))
+	if err != nil || len(files) == 0 {
+		return t, err
+	}
+	return t.ParseFiles(files...)
+}
+
+// parseAllTemplates parses the templates for every language.
+func parseAllTemplates() (map[string]*template.Template, error) {
+	sets := make(map[string]*template.Template)
+	for _, lang := range languages() {
+		t, err := parseTemplates(lang)
+		if err != nil {
+			return nil, err
+		}
+		sets[lang] = t
+	}
+	return sets, nil
+}
+
+// localizedMax bounds the number of template sets in localized. The
+// preferences come from cookies and every time zone makes another set,
+// so the least recently used sets are dropped past it.
+const localizedMax = 64
+
+// localized caches the templates of each language and set of reader
+// preferences, most recently used first. They are cloned from the
+// templates of the language, which are never executed themselves so
+// that they can be cloned.
+var localized = struct {
+	sync.Mutex
+	m     map[string]*list.Element
+	order *list.List
+}{m: make(map[string]*list.Element), order: list.New()}
+
+// A localizedSet is an entry of localized.
+type localizedSet struct {
+	key string
+	t   *template.Template
+}
+
+// currentTemplates returns the templates to render with in lang for a
+// reader with the preferences rp. With -dev they are parsed again on
+// every call.
+func currentTemplates(lang string, rp readerPrefs) (*template.Template, error) {
+	if *devMode {
+		t, err := parseTemplates(lang)
+		if err != nil {
+			return nil, err
+		}
+		return t.Funcs(rp.funcs()), nil
+	}
+	key := lang + " " + rp.key()
+	localized.Lock()
+	defer localized.Unlock()
+	if e, ok := localized.m[key]; ok {
+		localized.order.MoveToFront(e)
+		return e.Value.(*localizedSet).t, nil
+	}
+	t, err := templates[lang].Clone()
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs(rp.funcs())
+	localized.m[key] = localized.order.PushFront(&localizedSet{key, t})
+	if localized.order.Len() > localizedMax {
+		last := localized.order.Back()
+		localized.order.Remove(last)
+		delete(localized.m, last.Value.(*localizedSet).key)
+	}
+	return t, nil
+}
+
+// A Page can choose another layout for its view with a "layout" key in
+// its front matter. Layout "landing" is rendered with view-landing.html,
+// which must exist in the theme or the working directory.
+func layoutTemplate(layout string) string {
+	return "view-" + layout
+}
+
+// viewTemplate returns the template p is viewed with. Pages asking for a
+// layout that does not exist, say because the theme changed, fall back
+// to view.
+func viewTemplate(p *Page) string {
+	layout := pageMeta(p.Body)["layout"]
+	if schema := schemaFor(p.Title); layout == "" && schema != nil {
+		layout = schema.Layout
+	}
+	if layout == "" {
+		return "view"
+	}
+	t, err := currentTemplates("en", readerPrefs{Loc: time.Local})
+	if err != nil || t.Lookup(layoutTemplate(layout)+".html") == nil {
+		return "view"
+	}
+	return layoutTemplate(layout)
+}
+
+// checkLayout returns an error if p asks for a layout that does not
+// exist.
+func checkLayout(p *Page) error {
+	layout := pageMeta(p.Body)["layout"]
+	if layout == "" {
+		return nil
+	}
+	t, err := currentTemplates("en", readerPrefs{Loc: time.Local})
+	if err != nil {
+		return err
+	}
+	if t.Lookup(layoutTemplate(layout)+".html") == nil {
+		return newUserError("unknown layout %q", layout)
+	}
+	return nil
+}
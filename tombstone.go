@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var trashDir = flag.String("trash-dir", "deleted", "`dir` the tombstones of deleted pages are kept in")
+
+// A tombstone is what is left of a deleted page: its last body, who
+// deleted it and why. Pages can be restored from their tombstones, which
+// are kept afterwards as a record.
+type tombstone struct {
+	Title      string
+	Body       []byte
+	Reason     string
+	By         string
+	DeletedAt  time.Time
+	RestoredAt time.Time `json:",omitempty"`
+
+	file string
+}
+
+var (
+	errNoReason   = errors.New("a reason is required to delete a page")
+	errNoTomb     = errors.New("no deleted page to restore")
+	errPageExists = errors.New("the page exists")
+)
+
+func init() {
+	registerSpecial("DeletedPages", &specialPage{
+		Description: "Deleted pages, who deleted them and why.",
+		Data: func(r *http.Request) (interface{}, error) {
+			tombs, err := loadTombstones("")
+			if err != nil {
+				return nil, err
+			}
+			items := make([]specialItem, len(tombs))
+			for i, t := range tombs {
+				note := fmt.Sprintf("deleted by %s: %s", t.By, t.Reason)
+				if !t.RestoredAt.IsZero() {
+					note += "; restored " + t.RestoredAt.Format("2006-01-02 15:04")
+				}
+				items[i] = pageItem(t.Title, note)
+				items[i].Time = t.DeletedAt
+			}
+			return items, nil
+		},
+	})
+}
+
+// trashPage deletes the Page title, leaving a tombstone that records
+// reason and by, who deleted it.
+func trashPage(title, reason, by string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errNoReason
+	}
+	p, err := loadPage(title)
+	if err != nil {
+		return err
+	}
+	t := &tombstone{Title: title, Body: p.Body, Reason: reason, By: by, DeletedAt: time.Now().UTC()}
+	t.file = filepath.Join(*trashDir, title+"-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".json")
+	if err := t.write(); err != nil {
+		return err
+	}
+	if err := deletePage(title); err != nil {
+		os.Remove(t.file)
+		return err
+	}
+	return nil
+}
+
+func (t *tombstone) write() error {
+	if err := os.MkdirAll(*trashDir, 0700); err != nil {
+		return err
+	}
+	body, err := sealBody(t.Body)
+	if err != nil {
+		return err
+	}
+	sealed := *t
+	sealed.Body = body
+	b, err := json.Marshal(&sealed)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.file, b, 0600)
+}
+
+// loadTombstones returns the tombstones of title, or of every page if
+// title is empty, newest first.
+func loadTombstones(title string) ([]*tombstone, error) {
+	pattern := "*-*.json"
+	if title != "" {
+		pattern = title + "-*.json"
+	}
+	files, err := filepath.Glob(filepath.Join(*trashDir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	var tombs []*tombstone
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		t := &tombstone{file: f}
+		if err := json.Unmarshal(b, t); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if title != "" && t.Title != title {
+			continue // another title with the same prefix
+		}
+		if t.Body, err = openBody(t.Body); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		tombs = append(tombs, t)
+	}
+	sort.Slice(tombs, func(i, j int) bool { return tombs[i].DeletedAt.After(tombs[j].DeletedAt) })
+	return tombs, nil
+}
+
+// restorePage brings back the Page title as it was when last deleted.
+func restorePage(title string) (*Page, error) {
+	defer lockPages(title)()
+	if _, err := os.Stat(pageFile(title)); err == nil {
+		return nil, errPageExists
+	}
+	tombs, err := loadTombstones(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(tombs) == 0 || !tombs[0].RestoredAt.IsZero() {
+		return nil, errNoTomb
+	}
+	t := tombs[0]
+	p := &Page{Title: title, Body: t.Body}
+	if err := savePage(p); err != nil {
+		return nil, err
+	}
+	t.RestoredAt = time.Now().UTC()
+	return p, t.write()
+}
+
+// Handler for POST /api/v1/pages/<title>/restore, which restores the
+// page from its latest tombstone.
+func restoreAPIHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !apiAuthorized(w, r) {
+		return
+	}
+	res := opResult{Op: "restore", Title: title, Status: http.StatusOK}
+	p, err := restorePage(title)
+	switch err {
+	case nil:
+		res.ETag = pageETag(p.Body)
+		w.Header().Set("ETag", res.ETag)
+	case errNoTomb:
+		res.Status, res.Error = http.StatusNotFound, err.Error()
+	case errPageExists:
+		res.Status, res.Error = http.StatusConflict, err.Error()
+	default:
+		res.Status, res.Error = saveStatus(err), err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+// A trusted HTML block is raw HTML in a page, shown as it is instead of
+// escaped, for embeds and widgets:
+//
+//	{{html}}
+//	<iframe src="https://www.youtube-nocookie.com/embed/..."></iframe>
+//	{{/html}}
+//
+// Like protection, trusted HTML is for the wiki's editors, the clients of
+// the API. Edits from the web form and by mail may keep the blocks a page
+// has but not add, change or remove them.
+var trustedBlock = regexp.MustCompile(`(?ms)^\{\{html\}\}\r?\n(.*?)^\{\{/html\}\}\r?$`)
+
+var errTrusted = errors.New("only editors can add or change trusted HTML blocks, through the API")
+
+// trustedBlocks returns the contents of the trusted HTML blocks of
+// content.
+func trustedBlocks(content []byte) [][]byte {
+	var blocks [][]byte
+	for _, m := range trustedBlock.FindAllSubmatch(content, -1) {
+		blocks = append(blocks, m[1])
+	}
+	return blocks
+}
+
+// withoutTrusted returns body without its trusted HTML blocks, for a
+// copy of a page made in the web editor, which could not save them.
+func withoutTrusted(body []byte) []byte {
+	return trustedBlock.ReplaceAll(body, nil)
+}
+
+// checkTrusted returns an error if an edit from outside the API changes
+// the trusted HTML blocks of p.
+func checkTrusted(p *Page) error {
+	var current [][]byte
+	if cur, err := loadPage(p.Title); err == nil {
+		current = trustedBlocks(pageContent(cur.Body))
+	}
+	blocks := trustedBlocks(pageContent(p.Body))
+	if len(blocks) != len(current) {
+		return errTrusted
+	}
+	for i := range blocks {
+		if !bytes.Equal(blocks[i], current[i]) {
+			return errTrusted
+		}
+	}
+	return nil
+}
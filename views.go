@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	viewsFile   = flag.String("views", "views.json", "`file` the daily page view counters are kept in")
+	viewsDedupe = flag.Bool("views-dedupe", false, "count each visitor once per page per day")
+)
+
+const (
+	// viewsKeepDays is how long daily counters are kept.
+	viewsKeepDays = 90
+	// viewsTrendDays is the period shown by the trend and MostViewed.
+	viewsTrendDays  = 30
+	mostViewedLimit = 20
+)
+
+// botAgent matches User-Agents that should not count as page views.
+var botAgent = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|preview|fetch|curl|wget|python|headless|^$`)
+
+// pageViews counts views of each page per day. Nothing about the viewer
+// is stored: with -views-dedupe a visitor is recognised by a hash of
+// their address and User-Agent salted with a value that is thrown away
+// at the end of each day, so visits cannot be linked across days.
+var pageViews = &viewCounter{}
+
+type viewCounter struct {
+	mu    sync.Mutex
+	days  map[string]map[string]int // title -> day -> views
+	dirty bool
+
+	day  string
+	salt []byte
+	seen map[[sha256.Size]byte]bool
+}
+
+func init() {
+	registerSpecial("MostViewed", &specialPage{
+		Description: fmt.Sprintf("The most viewed pages of the last %d days.", viewsTrendDays),
+		Data:        mostViewed,
+	})
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// load reads the counters saved by flush.
+func (c *viewCounter) load(filename string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.days = make(map[string]map[string]int)
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &c.days)
+}
+
+// flush writes the counters to filename if they have changed, dropping
+// days older than viewsKeepDays.
+func (c *viewCounter) flush(filename string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -viewsKeepDays).Format("2006-01-02")
+	for title, days := range c.days {
+		for day := range days {
+			if day < cutoff {
+				delete(days, day)
+			}
+		}
+		if len(days) == 0 {
+			delete(c.days, title)
+		}
+	}
+	b, err := json.Marshal(c.days)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, b, 0600); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// count records a view of title by r unless r comes from a bot.
+func (c *viewCounter) count(r *http.Request, title string) {
+	if botAgent.MatchString(r.UserAgent()) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	day := today()
+	if *viewsDedupe {
+		if day != c.day {
+			c.day, c.salt, c.seen = day, make([]byte, 16), make(map[[sha256.Size]byte]bool)
+			rand.Read(c.salt)
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		visitor := sha256.Sum256([]byte(string(c.salt) + host + "\x00" + r.UserAgent() + "\x00" + title))
+		if c.seen[visitor] {
+			return
+		}
+		c.seen[visitor] = true
+	}
+	days := c.days[title]
+	if days == nil {
+		days = make(map[string]int)
+		c.days[title] = days
+	}
+	days[day]++
+	c.dirty = true
+}
+
+// A trendPoint is the number of views of a page on one day. Height is
+// the count scaled to the busiest day of the trend, out of 100.
+type trendPoint struct {
+	Day    string `json:"day"`
+	Views  int    `json:"views"`
+	Height int    `json:"-"`
+}
+
+// trend returns the daily views of title over the last n days, oldest
+// first, and the total.
+func (c *viewCounter) trend(title string, n int) ([]trendPoint, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	points := make([]trendPoint, n)
+	total, max := 0, 0
+	now := time.Now()
+	for i := range points {
+		day := now.AddDate(0, 0, i-n+1).Format("2006-01-02")
+		views := c.days[title][day]
+		points[i] = trendPoint{Day: day, Views: views}
+		total += views
+		if views > max {
+			max = views
+		}
+	}
+	if max > 0 {
+		for i := range points {
+			points[i].Height = points[i].Views * 100 / max
+		}
+	}
+	return points, total
+}
+
+// A viewTrend is what view.html shows about how often a page is read.
+type viewTrend struct {
+	Days   int
+	Total  int
+	Points []trendPoint
+}
+
+// viewTrendFunc is the viewTrend template function.
+func viewTrendFunc(title string) *viewTrend {
+	points, total := pageViews.trend(title, viewsTrendDays)
+	return &viewTrend{Days: viewsTrendDays, Total: total, Points: points}
+}
+
+func mostViewed(r *http.Request) (interface{}, error) {
+	pageViews.mu.Lock()
+	titles := make([]string, 0, len(pageViews.days))
+	for title := range pageViews.days {
+		titles = append(titles, title)
+	}
+	pageViews.mu.Unlock()
+
+	totals := make(map[string]int, len(titles))
+	for _, title := range titles {
+		_, totals[title] = pageViews.trend(title, viewsTrendDays)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		if totals[titles[i]] != totals[titles[j]] {
+			return totals[titles[i]] > totals[titles[j]]
+		}
+		return titles[i] < titles[j]
+	})
+	var items []specialItem
+	for _, title := range titles {
+		if len(items) == mostViewedLimit || totals[title] == 0 {
+			break
+		}
+		items = append(items, pageItem(title, fmt.Sprintf("%d views", totals[title])))
+	}
+	return items, nil
+}
+
+// Handler for /api/v1/views/<title>, the JSON form of a page's view trend.
+func viewsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, "/api/v1/views/")
+	if !titleValidator.MatchString(title) {
+		http.NotFound(w, r)
+		return
+	}
+	points, total := pageViews.trend(title, viewsTrendDays)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Title string       `json:"title"`
+		Total int          `json:"total"`
+		Days  []trendPoint `json:"days"`
+	}{title, total, points})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watchKeep is how many change events are kept for watchers to
+	// catch up on.
+	watchKeep = 1000
+	// watchTimeout is how long a watch request waits for changes by
+	// default, and watchMaxTimeout how long it may ask to wait.
+	watchTimeout    = 30 * time.Second
+	watchMaxTimeout = 120 * time.Second
+)
+
+// A changeEvent reports that a page was saved, deleted or moved.
+type changeEvent struct {
+	Cursor string    `json:"cursor"`
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	From   string    `json:"from,omitempty"` // the old title, for moves
+	Time   time.Time `json:"time"`
+
+	seq int64
+}
+
+// changes keeps the latest change events for /api/v1/watch. They live in
+// memory, so cursors carry the time the server started and one from an
+// earlier run is known to have missed events.
+var changes = &changeLog{
+	boot: strconv.FormatInt(time.Now().UnixNano(), 36),
+	wake: make(chan struct{}),
+}
+
+func init() {
+	onPageSaved(func(p *Page) { changes.record("save", p.Title, "") })
+	onPageDeleted(func(title string) { changes.record("delete", title, "") })
+	onPageMoved(func(from, to string) { changes.record("move", to, from) })
+}
+
+type changeLog struct {
+	mu     sync.Mutex
+	boot   string
+	seq    int64
+	events []changeEvent
+	// wake is closed, and replaced, when an event is recorded.
+	wake chan struct{}
+}
+
+func (l *changeLog) cursor(seq int64) string {
+	return l.boot + "-" + strconv.FormatInt(seq, 10)
+}
+
+// record adds an event of type typ for title.
+func (l *changeLog) record(typ, title, from string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.events = append(l.events, changeEvent{
+		Cursor: l.cursor(l.seq),
+		Type:   typ,
+		Title:  title,
+		From:   from,
+		Time:   time.Now().UTC(),
+		seq:    l.seq,
+	})
+	if len(l.events) > watchKeep {
+		l.events = append([]changeEvent(nil), l.events[len(l.events)-watchKeep:]...)
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// since returns the events after cursor, the cursor of the last event and
+// a channel closed when there are more. Reset is true if events after
+// cursor were lost, because they were dropped or the server restarted.
+func (l *changeLog) since(cursor string) (events []changeEvent, last string, reset bool, wake chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := int64(-1)
+	if i := strings.LastIndex(cursor, "-"); i >= 0 && cursor[:i] == l.boot {
+		if n, err := strconv.ParseInt(cursor[i+1:], 10, 64); err == nil && n <= l.seq {
+			seq = n
+		}
+	}
+	if seq < 0 {
+		// An unknown cursor starts from now.
+		return nil, l.cursor(l.seq), true, l.wake
+	}
+	if len(l.events) > 0 && l.events[0].seq > seq+1 {
+		reset = true
+	}
+	for _, e := range l.events {
+		if e.seq > seq {
+			events = append(events, e)
+		}
+	}
+	return events, l.cursor(l.seq), reset, l.wake
+}
+
+// Handler for GET /api/v1/watch?since=<cursor>. It answers with the
+// change events after the cursor as soon as there are any, or with none
+// after timeout seconds. Clients pass the returned cursor to their next
+// request. Without since, or with a cursor the server no longer knows, it
+// answers at once with the current cursor and reset set, and the client
+// should reload whatever it follows.
+func watchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := watchTimeout
+	if s := r.FormValue("timeout"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("bad timeout %q", s), http.StatusBadRequest)
+			return
+		}
+		if timeout = time.Duration(n) * time.Second; timeout > watchMaxTimeout {
+			timeout = watchMaxTimeout
+		}
+	}
+	since := r.FormValue("since")
+	events, cursor, reset, wake := changes.since(since)
+	if len(events) == 0 && !reset {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-wake:
+			events, cursor, reset, _ = changes.since(since)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if events == nil {
+		events = []changeEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Cursor string        `json:"cursor"`
+		Reset  bool          `json:"reset"`
+		Events []changeEvent `json:"events"`
+	}{cursor, reset, events})
+}
@@ -1,83 +1,333 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"html/template"
 	"net/http"
 	"regexp"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-const lenPath = len("/view/")
-
 var  (
-	// If the templates can't be loaded exit the program (panic).
-	templates = template.Must(template.ParseFiles("edit.html", "view.html"))
+	wikiName = flag.String("name", "gowiki", "`name` of the wiki shown in page metadata and preview cards")
+	// templates holds the templates of each language. They are parsed in
+	// main, once the theme is known.
+	templates map[string]*template.Template
 	// Prevent arbitrary paths being read/written on the server.
 	titleValidator = regexp.MustCompile("^[a-zA-Z0-9]+$")
 )
 
+// templateFuncs are the functions templates can call. They are part of
+// the template contract described in the README.
+var templateFuncs = template.FuncMap{
+	"challenge":    challengeWidget,
+	"viewTrend":    viewTrendFunc,
+	"render":       renderBody,
+	"related":      relatedPages,
+	"excerpt":      excerpt,
+	"firstImage":   firstImage,
+	"siteName":     func() string { return *wikiName },
+	"absURL":       absURL,
+	"scheduledAt":  scheduledAt,
+	"expiredSince": expiredSince,
+	"truncate":     truncate,
+	"markup":       markup,
+	"url":          wikiURL,
+	"includePage":  includePage,
+	"etag":         pageETag,
+	"protected":    isProtected,
+	"meta":         pageMeta,
+}
+
 // Page represents a wiki page in memory.
 type Page struct {
 	Title string
 	Body  []byte
 }
 
+// pageFile returns the name of the file a Page is stored in.
+func pageFile(title string) string {
+	return title + ".txt"
+}
+
 // Save Page Body to a text file using the Title as the filename.
+// The Body is encrypted first if an encryption key is configured.
 func (p *Page) save() error {
-	filename := p.Title + ".txt"
-	return ioutil.WriteFile(filename, p.Body, 0600)
+	filename := pageFile(p.Title)
+	body, err := sealBody(p.Body)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, body, 0600)
+}
+
+// savePage saves p and announces it, which brings the wiki's indexes up
+// to date.
+func savePage(p *Page) error {
+	if err := checkQuota(p); err != nil {
+		return err
+	}
+	return storePage(p)
+}
+
+// storePage saves p and announces it like savePage, without checking
+// the quota, for changes that do not add to the wiki such as a rename.
+func storePage(p *Page) error {
+	var old []byte
+	if q, err := loadPage(p.Title); err == nil {
+		old = q.Body
+	}
+	if err := p.save(); err != nil {
+		return err
+	}
+	pageEvents.pageSaved(p, old)
+	return nil
+}
+
+// deletePage removes the Page title and announces it.
+func deletePage(title string) error {
+	if err := os.Remove(pageFile(title)); err != nil {
+		return err
+	}
+	pageEvents.pageDeleted(title)
+	return nil
 }
 
 // Load the file into memory and return a pointer to the Page.
 func loadPage(title string) (*Page, error) {
-	filename := title + ".txt"
+	filename := pageFile(title)
 	body, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	if body, err = openBody(body); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
 	return &Page{Title: title, Body: body}, nil
 }
 
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	err := templates.ExecuteTemplate(w, tmpl+".html", p)
+// listPages returns the sorted titles of every Page in the wiki.
+func listPages() ([]string, error) {
+	files, err := filepath.Glob(pageFile("*"))
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for _, f := range files {
+		title := strings.TrimSuffix(f, filepath.Ext(f))
+		if titleValidator.MatchString(title) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	lang := requestLang(r)
+	t, err := currentTemplates(lang, readerPrefsOf(r))
+	if err != nil {
+		http.Error(w, "template error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Language", lang)
+	err = t.ExecuteTemplate(w, tmpl+".html", data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 // Handler to view a wiki Page.
+// The Page is rendered as HTML unless the client asked for its raw text
+// or a JSON document (see negotiate).
 func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
 	p, err := loadPage(title)
+	format := requestFormat(r)
 	if err != nil {
+		if format != formatHTML {
+			http.NotFound(w, r)
+			return
+		}
 		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
 		return
 	}
-	renderTemplate(w, "view", p)
+	switch format {
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(p.Body)
+	case formatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Title string   `json:"title"`
+			Body  string   `json:"body"`
+			Links []string `json:"links"`
+		}{p.Title, string(p.Body), pageLinks(p.Body)})
+	default:
+		pageViews.count(r, title)
+		renderTemplate(w, r, viewTemplate(p), p)
+	}
+}
+
+// Handler for a printable view of a wiki Page, without navigation.
+func printHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := loadPage(title)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "print", p)
 }
 
 // Handler to edit a wiki Page.
 func editHandler(w http.ResponseWriter, r *http.Request, title string) {
 	p, err := loadPage(title)
-	if err != nil {
+	exists := err == nil
+	if !exists {
 		p = &Page{Title: title}
 	}
-	renderTemplate(w, "edit", p)
+	session, err := editSession(w, r)
+	if err != nil {
+		http.Error(w, errorText(r, err), http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		*Page
+		ETag      string
+		EditToken string
+		Schema    string
+		Fields    []schemaInput
+		Content   string
+		Templates []string
+	}{Page: p, ETag: pageETag(p.Body), EditToken: editTokens.issue(title, session)}
+	if !exists {
+		if from := r.FormValue("from"); from != "" {
+			// A copy of another page, from /duplicate/. Its trusted HTML
+			// blocks are left out, as the web form cannot add them.
+			if !titleValidator.MatchString(from) {
+				http.NotFound(w, r)
+				return
+			}
+			body, err := duplicateBody(from)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			p.Body = withoutTrusted(body)
+		} else if name := r.FormValue("template"); name != "" {
+			body, err := fillTemplate(name, title, time.Now().In(viewerLocation(r)))
+			if err != nil {
+				http.Error(w, errorText(r, err), http.StatusNotFound)
+				return
+			}
+			p.Body = body
+		} else {
+			data.Templates = newPageTemplates()
+		}
+	}
+	if schema := schemaFor(title); schema != nil {
+		data.Schema, data.Fields, data.Content = schema.Name, schema.inputs(p.Body), string(pageContent(p.Body))
+	}
+	renderTemplate(w, r, "edit", data)
 }
 
 // Handler to save a wiki Page.
 // The Page Title (provided in the URL) and the form's only field, Body, 
-// are stored in a new Page. The save() method is then called to write the
+// are stored in a new Page. savePage is then called to write the
 // data to a file, and the client is redirected to the /view/ page.
 func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
+	// The page is locked from checking the edit token and reading the
+	// version the edit started from until the edit is saved and the token
+	// spent, so neither check can miss a save made in between.
+	defer lockPages(title)()
+	if err := editTokens.verify(r, title); err != nil {
+		http.Error(w, errorText(r, err), http.StatusForbidden)
+		return
+	}
+	if saveChallenge != nil {
+		if err := saveChallenge.Verify(r); err != nil {
+			http.Error(w, errorText(r, err), http.StatusForbidden)
+			return
+		}
+	}
 	body := r.FormValue("body")
 	// The value returned by FormValue is of type string.
 	// Convert the value to []byte so it will fit in the Page struct.
 	p := &Page{Title: title, Body: []byte(body)}
-	err := p.save()
+	if schema := schemaFor(title); schema != nil && r.FormValue("schema") == schema.Name {
+		// The form has the schema's fields and the text below them.
+		var current []byte
+		if cur, err := loadPage(title); err == nil {
+			current = cur.Body
+		}
+		var err error
+		if p.Body, err = schema.build(r, current); err != nil {
+			http.Error(w, errorText(r, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if etag := r.FormValue("etag"); etag != "" {
+		var current []byte
+		if cur, err := loadPage(title); err == nil {
+			current = cur.Body
+		}
+		if pageETag(current) != etag {
+			http.Error(w, tr(r, "This page was changed while you were editing it. Copy your text, reload the editor and apply your changes again."), http.StatusConflict)
+			return
+		}
+	}
+	if err := checkProtected(p); err != nil {
+		http.Error(w, errorText(r, err), http.StatusForbidden)
+		return
+	}
+	if err := checkTrusted(p); err != nil {
+		http.Error(w, errorText(r, err), http.StatusForbidden)
+		return
+	}
+	if !checkEditWar(w, r, title) {
+		return
+	}
+	if !filterSave(w, r, p) {
+		return
+	}
+	if err := checkLayout(p); err != nil {
+		http.Error(w, errorText(r, err), http.StatusBadRequest)
+		return
+	}
+	if v := r.FormValue("publish_at"); v != "" {
+		at, err := time.ParseInLocation("2006-01-02T15:04", v, viewerLocation(r))
+		if err != nil {
+			http.Error(w, tr(r, "bad publish time %q", v), http.StatusBadRequest)
+			return
+		}
+		if at.After(time.Now()) {
+			// savePage checks the quota of edits published now.
+			if err := checkQuota(p); err != nil {
+				http.Error(w, errorText(r, err), http.StatusInsufficientStorage)
+				return
+			}
+			if err := scheduleEdit(p, at); err != nil {
+				http.Error(w, errorText(r, err), http.StatusInternalServerError)
+				return
+			}
+			editTokens.spend(r)
+			http.Redirect(w, r, "/view/"+title, http.StatusFound)
+			return
+		}
+	}
+	err := savePage(p)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, errorText(r, err), saveStatus(err))
 		return
 	}
+	editTokens.spend(r)
 	http.Redirect(w, r, "/view/"+title, http.StatusFound)
 }
 
@@ -86,8 +336,9 @@ func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
 func makeHandler(fn func (http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract the Page title from the Request and call the provided
-		// handler 'fn'
-		title := r.URL.Path[lenPath:]
+		// handler 'fn'. The title follows the handler's prefix, such as
+		// /view/ or /print/.
+		title := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+2:]
 		if !titleValidator.MatchString(title) {
 			http.NotFound(w, r)
 			return
@@ -97,8 +348,131 @@ func makeHandler(fn func (http.ResponseWriter, *http.Request, string)) http.Hand
 }
 
 func main() {
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
+	flag.Parse()
+	var err error
+	if err := loadCatalogs(); err != nil {
+		log.Fatal(err)
+	}
+	// If the templates can't be loaded exit the program, unless -dev
+	// will report the error on every request until it is fixed.
+	if templates, err = parseAllTemplates(); err != nil && !*devMode {
+		log.Fatal(err)
+	}
+	if err := setupEncryption(); err != nil {
+		log.Fatal(err)
+	}
+	if saveChallenge, err = newChallenge(*challengeKind); err != nil {
+		log.Fatal(err)
+	}
+	if *blockListFile != "" {
+		if err := blocks.reload(*blockListFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *gitRepo != "" && *gitSecret == "" {
+		log.Fatal("-git-repo needs -git-secret")
+	}
+	if *mailDomain != "" && *mailSecret == "" {
+		log.Fatal("-mail-domain needs -mail-secret")
+	}
+	if *interwikiFile != "" {
+		if err := interwiki.reload(*interwikiFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *emojiFile != "" {
+		if err := emoji.reload(*emojiFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *schemaFile != "" {
+		if err := loadSchemas(*schemaFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkEmbedProviders(); err != nil {
+		log.Fatal(err)
+	}
+	if *notifyFile != "" {
+		if err := loadNotifyChannels(*notifyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := setupActivityPub(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupSpamFilters(); err != nil {
+		log.Fatal(err)
+	}
+	if err := seedPages(); err != nil {
+		log.Fatal(err)
+	}
+	if err := stats.scan(); err != nil {
+		log.Fatal(err)
+	}
+	if err := linkGraph.scan(); err != nil {
+		log.Fatal(err)
+	}
+	if err := pageIndex.scan(); err != nil {
+		log.Fatal(err)
+	}
+	if err := expiry.scan(); err != nil {
+		log.Fatal(err)
+	}
+	if err := linkCheck.scan(); err != nil {
+		log.Fatal(err)
+	}
+	if err := pageViews.load(*viewsFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := addJob("flush-views", "@every 1m", 0, func() error { return pageViews.flush(*viewsFile) }); err != nil {
+		log.Fatal(err)
+	}
+	if err := addJob("publish-scheduled", "@every 30s", 0, publishDue); err != nil {
+		log.Fatal(err)
+	}
+	if err := addJob("check-expiry", "@hourly", 0, func() error { expiry.check(); return nil }); err != nil {
+		log.Fatal(err)
+	}
+	if err := addJob("expire-changes", "@daily", time.Hour, expireChanges); err != nil {
+		log.Fatal(err)
+	}
+	if *linkCheckSchedule != "" {
+		if err := addJob("check-links", *linkCheckSchedule, 10*time.Minute, func() error { linkCheck.check(); return nil }); err != nil {
+			log.Fatal(err)
+		}
+	}
+	startJobs()
+	startQueue()
+	http.HandleFunc("/view/", negotiate(makeHandler(viewHandler)))
+	http.HandleFunc("/edit/", checkBlocked(makeHandler(editHandler)))
+	http.HandleFunc("/save/", checkBlocked(makeHandler(saveHandler)))
+	http.HandleFunc("/card/", makeHandler(cardHandler))
+	http.HandleFunc("/print/", makeHandler(printHandler))
+	http.HandleFunc("/duplicate/", makeHandler(duplicateHandler))
+	http.HandleFunc("/special/", specialHandler)
+	http.HandleFunc("/prefs", prefsHandler)
+	http.HandleFunc("/graph", graphHandler)
+	http.HandleFunc("/diff", diffHandler)
+	http.HandleFunc("/change/", changeHandler)
+	http.HandleFunc("/calendar", calendarHandler)
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(themeDir("static")))))
+	http.HandleFunc("/api/v1/views/", cors(viewsAPIHandler))
+	http.HandleFunc("/api/v1/related/", cors(relatedAPIHandler))
+	http.HandleFunc("/api/v1/graph", cors(graphAPIHandler))
+	http.HandleFunc("/api/v1/diff", cors(diffAPIHandler))
+	http.HandleFunc("/api/v1/pages/", cors(checkBlocked(pagesAPIHandler)))
+	http.HandleFunc("/api/v1/batch", cors(checkBlocked(batchAPIHandler)))
+	http.HandleFunc("/api/v1/watch", cors(watchAPIHandler))
+	http.HandleFunc("/sitemap.xml", sitemapHandler)
+	http.HandleFunc("/robots.txt", robotsHandler)
+	http.HandleFunc("/hooks/git", gitHookHandler)
+	http.HandleFunc("/hooks/mail", mailHookHandler)
+	http.HandleFunc("/.well-known/webfinger", webfingerHandler)
+	http.HandleFunc("/ap/actor", actorHandler)
+	http.HandleFunc("/ap/inbox", inboxHandler)
+	http.HandleFunc("/ap/outbox", outboxHandler)
+	http.HandleFunc("/ap/followers", followersHandler)
+	http.HandleFunc("/ap/notes/", noteHandler)
 	http.ListenAndServe(":8080", nil)
 }